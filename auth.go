@@ -0,0 +1,174 @@
+package hls
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authorizer decides whether a request may view a stream
+type Authorizer interface {
+	// Authorize returns an error if the request should be rejected. Otherwise
+	// it returns query parameters to add to every URI in the playlists served
+	// in response, so that the player's subsequent requests are also
+	// authorized.
+	Authorize(req *http.Request, stream string) (url.Values, error)
+}
+
+// TokenAuth authorizes requests carrying a token created by Sign
+type TokenAuth struct {
+	// Key is the secret used to sign tokens. If it is empty then every request is rejected.
+	Key []byte
+	// Param is the query parameter holding the token. Defaults to "token".
+	Param string
+	// ClientIP gets the address of the client when tokens are bound to one. Defaults to the host part of the request's RemoteAddr, which is incorrect behind a proxy.
+	ClientIP func(req *http.Request) string
+}
+
+var (
+	errNoToken      = errors.New("token required")
+	errBadToken     = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+	errNoKey        = errors.New("token key not configured")
+)
+
+// Sign creates a token permitting the named stream to be viewed until the given time. If clientIP is not empty then the token is only valid for that client.
+func (a *TokenAuth) Sign(stream string, expires time.Time, clientIP string) string {
+	exp := strconv.FormatInt(expires.Unix(), 36)
+	bound := "a"
+	if clientIP != "" {
+		bound = "i"
+	}
+	return exp + "." + bound + "." + a.sign(stream, exp, clientIP)
+}
+
+func (a *TokenAuth) sign(stream, exp, clientIP string) string {
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write([]byte(stream + "\n" + exp + "\n" + clientIP))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize checks that the request carries a valid token for the stream
+func (a *TokenAuth) Authorize(req *http.Request, stream string) (url.Values, error) {
+	if len(a.Key) == 0 {
+		// anyone could sign tokens with an empty key
+		return nil, errNoKey
+	}
+	param := a.Param
+	if param == "" {
+		param = "token"
+	}
+	token := req.URL.Query().Get(param)
+	if token == "" {
+		return nil, errNoToken
+	}
+	f := strings.Split(token, ".")
+	if len(f) != 3 {
+		return nil, errBadToken
+	}
+	var clientIP string
+	switch f[1] {
+	case "a":
+	case "i":
+		clientIP = a.clientIP(req)
+	default:
+		return nil, errBadToken
+	}
+	if !hmac.Equal([]byte(f[2]), []byte(a.sign(stream, f[0], clientIP))) {
+		return nil, errBadToken
+	}
+	exp, err := strconv.ParseInt(f[0], 36, 64)
+	if err != nil {
+		return nil, errBadToken
+	} else if time.Now().Unix() >= exp {
+		return nil, errTokenExpired
+	}
+	return url.Values{param: []string{token}}, nil
+}
+
+func (a *TokenAuth) clientIP(req *http.Request) string {
+	if a.ClientIP != nil {
+		return a.ClientIP(req)
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// check the request against the authorizer, if any, and return the query string to propagate into playlists
+func (p *Publisher) authorize(rw http.ResponseWriter, req *http.Request) (query string, ok bool) {
+	if p.Authorizer == nil {
+		return "", true
+	}
+	values, err := p.Authorizer.Authorize(req, p.Name)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return "", false
+	}
+	return values.Encode(), true
+}
+
+var (
+	hlsURIAttr  = regexp.MustCompile(`URI="[^"]*`)
+	dashURIAttr = regexp.MustCompile(`(?:initialization|media)="[^"]*|<PatchLocation[^>]*>[^<]*`)
+)
+
+// add a query string to every URI in a HLS playlist
+func addPlaylistQuery(playlist []byte, query string) []byte {
+	if query == "" {
+		return playlist
+	}
+	var b bytes.Buffer
+	b.Grow(len(playlist) * 2)
+	for _, line := range bytes.SplitAfter(playlist, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] == '#' {
+			b.Write(hlsURIAttr.ReplaceAllFunc(line, func(m []byte) []byte {
+				return appendQuery(m, query, "&")
+			}))
+			continue
+		}
+		uri := bytes.TrimRight(line, "\n")
+		if len(uri) == 0 {
+			b.Write(line)
+			continue
+		}
+		b.Write(appendQuery(uri, query, "&"))
+		b.Write(line[len(uri):])
+	}
+	return b.Bytes()
+}
+
+// add a query string to the segment URIs of a DASH MPD
+func addMPDQuery(mpd []byte, query string) []byte {
+	if query == "" {
+		return mpd
+	}
+	// the URIs are already escaped, so the query and separator must be too
+	query = strings.ReplaceAll(query, "&", "&amp;")
+	return dashURIAttr.ReplaceAllFunc(mpd, func(m []byte) []byte {
+		return appendQuery(m, query, "&amp;")
+	})
+}
+
+// append a query string to a URI, using amp to separate it from an existing one
+func appendQuery(uri []byte, query, amp string) []byte {
+	sep := "?"
+	if bytes.IndexByte(uri, '?') >= 0 {
+		sep = amp
+	}
+	return append(append([]byte(nil), uri...), sep+query...)
+}
@@ -0,0 +1,176 @@
+package hls
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls/dashmpd"
+)
+
+func TestTokenAuth(t *testing.T) {
+	a := &TokenAuth{Key: []byte("secret")}
+	future := time.Now().Add(time.Hour)
+	tamper := func(token string) string {
+		c := byte('A')
+		if token[len(token)-1] == c {
+			c = 'B'
+		}
+		return token[:len(token)-1] + string(c)
+	}
+	values := []struct {
+		Name     string
+		Auth     *TokenAuth
+		Stream   string
+		Token    string
+		RemoteIP string
+		Err      error
+	}{
+		{"valid", a, "live", a.Sign("live", future, ""), "192.0.2.1", nil},
+		{"valid for client", a, "live", a.Sign("live", future, "192.0.2.1"), "192.0.2.1", nil},
+		{"no token", a, "live", "", "192.0.2.1", errNoToken},
+		{"no key", &TokenAuth{}, "live", a.Sign("live", future, ""), "192.0.2.1", errNoKey},
+		{"expired", a, "live", a.Sign("live", time.Now().Add(-time.Second), ""), "192.0.2.1", errTokenExpired},
+		{"wrong stream", a, "other", a.Sign("live", future, ""), "192.0.2.1", errBadToken},
+		{"audio only stream", a, "live", a.Sign("live/audio", future, ""), "192.0.2.1", errBadToken},
+		{"wrong client", a, "live", a.Sign("live", future, "192.0.2.1"), "192.0.2.2", errBadToken},
+		{"client binding removed", a, "live", strings.Replace(a.Sign("live", future, "192.0.2.1"), ".i.", ".a.", 1), "192.0.2.1", errBadToken},
+		{"tampered MAC", a, "live", tamper(a.Sign("live", future, "")), "192.0.2.1", errBadToken},
+		{"extended expiry", a, "live", "z" + a.Sign("live", future, ""), "192.0.2.1", errBadToken},
+		{"wrong key", &TokenAuth{Key: []byte("other")}, "live", a.Sign("live", future, ""), "192.0.2.1", errBadToken},
+		{"malformed", a, "live", "abc", "192.0.2.1", errBadToken},
+	}
+	for _, ex := range values {
+		req := httptest.NewRequest("GET", "/live/main.m3u8?token="+ex.Token, nil)
+		req.RemoteAddr = ex.RemoteIP + ":1234"
+		q, err := ex.Auth.Authorize(req, ex.Stream)
+		if err != ex.Err {
+			t.Errorf("%s: expected error %v, got %v", ex.Name, ex.Err, err)
+		} else if err == nil && q.Get("token") != ex.Token {
+			t.Errorf("%s: expected token to be propagated, got %q", ex.Name, q.Encode())
+		}
+	}
+}
+
+func TestTokenClientIP(t *testing.T) {
+	a := &TokenAuth{
+		Key:      []byte("secret"),
+		Param:    "t",
+		ClientIP: func(req *http.Request) string { return req.Header.Get("X-Forwarded-For") },
+	}
+	token := a.Sign("live", time.Now().Add(time.Hour), "198.51.100.7")
+	req := httptest.NewRequest("GET", "/live/main.m3u8?t="+token, nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	if _, err := a.Authorize(req, "live"); err != nil {
+		t.Errorf("expected forwarded address to be used, got %v", err)
+	}
+	req.Header.Set("X-Forwarded-For", "198.51.100.8")
+	if _, err := a.Authorize(req, "live"); err != errBadToken {
+		t.Errorf("expected %v for another forwarded address, got %v", errBadToken, err)
+	}
+}
+
+func TestPlaylistQuery(t *testing.T) {
+	const playlist = `#EXTM3U
+#EXT-X-MAP:URI="0xinit.mp4"
+#EXTINF:2.000,
+0x1.m4s
+#EXT-X-PART:DURATION=0.200,URI="0x2.0.m4s",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="0x2.1.m4s"
+#EXT-X-RENDITION-REPORT:URI="1x.m3u8?_HLS_msn=2",LAST-MSN=2
+`
+	const expected = `#EXTM3U
+#EXT-X-MAP:URI="0xinit.mp4?token=a%2Fb&x=1"
+#EXTINF:2.000,
+0x1.m4s?token=a%2Fb&x=1
+#EXT-X-PART:DURATION=0.200,URI="0x2.0.m4s?token=a%2Fb&x=1",INDEPENDENT=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="0x2.1.m4s?token=a%2Fb&x=1"
+#EXT-X-RENDITION-REPORT:URI="1x.m3u8?_HLS_msn=2&token=a%2Fb&x=1",LAST-MSN=2
+`
+	if got := string(addPlaylistQuery([]byte(playlist), "token=a%2Fb&x=1")); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+	if got := string(addPlaylistQuery([]byte(playlist), "")); got != playlist {
+		t.Errorf("expected playlist to be unchanged without a query, got:\n%s", got)
+	}
+}
+
+func TestMPDQuery(t *testing.T) {
+	mpd := testMPD(0, testPeriod("p0", 1, dashmpd.Segment{Duration: 2}))
+	mpd.Period[0].AdaptationSet[0].SegmentTemplate.Media = "0x$Number$.m4s?v=1"
+	blob, err := xml.Marshal(mpd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob = addMPDQuery(blob, "token=a&b=c")
+	// the result must still be valid XML whose URIs carry the whole query
+	var got dashmpd.MPD
+	if err := xml.Unmarshal(blob, &got); err != nil {
+		t.Fatalf("%s\n%s", err, blob)
+	}
+	tmpl := got.Period[0].AdaptationSet[0].SegmentTemplate
+	for _, ex := range []struct{ Got, Expected string }{
+		{tmpl.Media, "0x$Number$.m4s?v=1&token=a&b=c"},
+		{tmpl.Initialization, "0-x-init.mp4?token=a&b=c"},
+		{got.PatchLocation.URL, mpd.PatchLocation.URL + "&token=a&b=c"},
+	} {
+		if ex.Got != ex.Expected {
+			t.Errorf("expected %q, got %q", ex.Expected, ex.Got)
+		}
+	}
+}
+
+func TestPublisherAuth(t *testing.T) {
+	a := &TokenAuth{Key: []byte("secret")}
+	p := &Publisher{Mode: ModeSeparateTracks, Name: "live", Authorizer: a}
+	if err := p.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	f := &feeder{p: p}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	token := a.Sign("live", time.Now().Add(time.Hour), "")
+	get := func(uri string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", uri, nil))
+		return rec
+	}
+	playlist := "/" + p.playlistName(0)
+	if rec := get(playlist); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a request without a token to be forbidden, got %d", rec.Code)
+	}
+	if rec := get("/" + p.segmentName(0, p.baseMSN, -1)); rec.Code != http.StatusForbidden {
+		t.Errorf("expected a segment request without a token to be forbidden, got %d", rec.Code)
+	}
+	rec := get(playlist + "?token=" + token)
+	if rec.Code != 200 {
+		t.Fatalf("expected playlist, got %d", rec.Code)
+	}
+	// every URI, including those of segments, parts and maps, carries the token
+	var uris int
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") && !strings.Contains(line, `URI="`) {
+			continue
+		}
+		uris++
+		if !strings.Contains(line, "token="+token) {
+			t.Errorf("URI without token: %s", line)
+		}
+	}
+	if uris == 0 {
+		t.Error("expected URIs in playlist")
+	}
+	rec = get("/" + p.segmentName(0, p.baseMSN, -1) + "?token=" + token)
+	if rec.Code != 200 {
+		t.Errorf("expected segment, got %d", rec.Code)
+	}
+	rec = get("/main.mpd?token=" + token)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "?token="+token+`"`) {
+		t.Errorf("expected MPD URIs to carry the token, got %d:\n%s", rec.Code, rec.Body)
+	}
+}
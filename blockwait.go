@@ -21,6 +21,9 @@ type (
 func (p *Publisher) waitForSegment(ctx context.Context, want segment.PartMSN) hlsState {
 	ctx, cancel := context.WithTimeout(ctx, 35*time.Second)
 	defer cancel()
+	m := p.stats()
+	m.blocked.Add(1)
+	defer m.blocked.Add(-1)
 	// subscribe to segment updates
 	ch := p.addSub()
 	defer p.delSub(ch)
@@ -103,7 +106,12 @@ func (p *Publisher) waitForEtag(req *http.Request, state hlsState) hlsState {
 	if previous == "" || state.mpd.etag == "" || state.mpd.etag != previous {
 		return state
 	}
-	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	return p.waitForMPD(req.Context(), previous)
+}
+
+// wait for the MPD to change from the version with the given etag
+func (p *Publisher) waitForMPD(ctx context.Context, previous string) hlsState {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	ch := p.addSub()
 	defer p.delSub(ch)
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"eaglesong.dev/hls/m3u8"
+)
+
+type checker struct {
+	client       *http.Client
+	reloads      int
+	noBlock      bool
+	noSegments   bool
+	pdtTolerance time.Duration
+	report       report
+
+	inits   map[string]*initSegment // checked initialization segments
+	checked map[string]bool         // checked media segments and parts
+}
+
+// check the playlist at uri and everything it refers to. Problems are added
+// to the report, and an error is returned only if checking couldn't continue.
+func (c *checker) run(ctx context.Context, uri string) error {
+	base, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	blob, err := c.fetch(ctx, base)
+	if err != nil {
+		return err
+	}
+	pl, err := m3u8.Parse(blob)
+	if err != nil {
+		return fmt.Errorf("%s: %w", uri, err)
+	}
+	master, ok := pl.(*m3u8.MasterPlaylist)
+	if !ok {
+		return c.checkMedia(ctx, base, pl.(*m3u8.MediaPlaylist))
+	}
+	var media []string
+	for _, r := range master.Renditions {
+		if r.URI != "" {
+			media = append(media, r.URI)
+		}
+	}
+	for _, s := range master.ImageStreams {
+		media = append(media, s.URI)
+	}
+	for _, v := range master.Variants {
+		media = append(media, v.URI)
+	}
+	if len(media) == 0 {
+		c.report.add("master", uri, "no variant streams")
+	}
+	for _, ref := range media {
+		u, err := base.Parse(ref)
+		if err != nil {
+			c.report.add("master", uri, "invalid URI %q", ref)
+			continue
+		}
+		if err := c.checkMedia(ctx, u, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check a media playlist by reloading it repeatedly. If pl is not nil then it
+// is the first version, already fetched.
+func (c *checker) checkMedia(ctx context.Context, base *url.URL, pl *m3u8.MediaPlaylist) error {
+	uri := base.String()
+	var prev *m3u8.MediaPlaylist
+	var msn int64
+	part := -1
+	var blocking bool
+	for i := 0; i <= c.reloads; i++ {
+		if pl == nil {
+			u := base
+			if blocking {
+				u = blockingURL(base, msn, part)
+			}
+			blob, err := c.fetch(ctx, u)
+			if err != nil {
+				return err
+			}
+			pl = new(m3u8.MediaPlaylist)
+			if err := pl.UnmarshalText(blob); err != nil {
+				c.report.add("parse", uri, "%s", err)
+				return nil
+			}
+			if blocking && !hasPart(pl, msn, part) {
+				c.report.add("blocking-reload", uri, "asked for part %d.%d but the last segment is %d", msn, part, lastMSN(pl))
+			}
+		}
+		checkPlaylist(&c.report, uri, pl, c.pdtTolerance)
+		if prev != nil {
+			checkReload(&c.report, uri, prev, pl)
+		}
+		if !c.noSegments {
+			if err := c.checkSegments(ctx, base, pl); err != nil {
+				return err
+			}
+		}
+		if pl.EndList {
+			break
+		}
+		msn, part, blocking = nextPart(pl)
+		if c.noBlock || !blocking {
+			blocking = false
+			// reload after half the target duration
+			select {
+			case <-time.After(time.Duration(pl.TargetDuration) * time.Second / 2):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		prev, pl = pl, nil
+	}
+	return nil
+}
+
+// add the blocking reload directives to a playlist URL
+func blockingURL(base *url.URL, msn int64, part int) *url.URL {
+	u := *base
+	q := u.Query()
+	q.Set("_HLS_msn", strconv.FormatInt(msn, 10))
+	if part >= 0 {
+		q.Set("_HLS_part", strconv.Itoa(part))
+	}
+	u.RawQuery = q.Encode()
+	return &u
+}
+
+func (c *checker) fetch(ctx context.Context, u *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls"
+	"eaglesong.dev/hls/m3u8"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+func testStreams() []av.CodecData {
+	v := h264parser.CodecData{}
+	v.RecordInfo.AVCProfileIndication = 0x64
+	v.RecordInfo.AVCLevelIndication = 0x1f
+	v.SPSInfo.Width, v.SPSInfo.Height = 1280, 720
+	a := aacparser.CodecData{Config: aacparser.MPEG4AudioConfig{SampleRate: 48000, ChannelLayout: av.CH_STEREO, ObjectType: 2}}
+	return []av.CodecData{v, a}
+}
+
+var testEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writes 30fps video with a keyframe every 2 seconds, and AAC audio
+type feeder struct {
+	p      *hls.Publisher
+	frames int
+	vt, at time.Duration
+}
+
+// write one video frame and the audio that precedes it
+func (f *feeder) frame() error {
+	for f.at <= f.vt {
+		if err := f.p.WriteExtendedPacket(hls.ExtendedPacket{Packet: av.Packet{Idx: 1, Time: f.at, Data: make([]byte, 100)}}); err != nil {
+			return err
+		}
+		f.at += 21333 * time.Microsecond
+	}
+	pkt := hls.ExtendedPacket{Packet: av.Packet{Idx: 0, Time: f.vt, IsKeyFrame: f.frames%60 == 0, Data: make([]byte, 1000)}}
+	if pkt.IsKeyFrame {
+		pkt.ProgramTime = testEpoch.Add(f.vt)
+	}
+	f.frames++
+	f.vt = time.Duration(f.frames) * time.Second / 30
+	return f.p.WriteExtendedPacket(pkt)
+}
+
+// write frames faster than real time until stop is closed
+func (f *feeder) run(stop <-chan struct{}) error {
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(time.Millisecond):
+		}
+		if err := f.frame(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	for _, mode := range []hls.Mode{hls.ModeSingleTrack, hls.ModeSeparateTracks} {
+		p := &hls.Publisher{Mode: mode}
+		if err := p.WriteHeader(testStreams()); err != nil {
+			t.Fatal(err)
+		}
+		// publish a few segments before starting
+		f := &feeder{p: p}
+		for f.vt < 5*time.Second {
+			if err := f.frame(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stop := make(chan struct{})
+		errch := make(chan error, 1)
+		go func() { errch <- f.run(stop) }()
+		srv := httptest.NewServer(p)
+		c := &checker{
+			client:       srv.Client(),
+			reloads:      6,
+			pdtTolerance: defaultPDTTolerance,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := c.run(ctx, srv.URL+"/"+p.Playlist())
+		cancel()
+		close(stop)
+		if ferr := <-errch; ferr != nil {
+			t.Error(ferr)
+		}
+		srv.Close()
+		p.Close()
+		if err != nil {
+			t.Fatalf("mode %d: %s", mode, err)
+		}
+		for _, prob := range c.report.problems {
+			t.Errorf("mode %d: %s", mode, prob)
+		}
+		if len(c.checked) == 0 {
+			t.Errorf("mode %d: no segments were checked", mode)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		playlist string
+		rule     string
+	}{
+		{"long segment", `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXTINF:2.600000,
+a.ts
+`, "target-duration"},
+		{"map version", `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:2.000000,
+a.m4s
+`, "version"},
+		{"long part", `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=1.000000
+#EXT-X-PART-INF:PART-TARGET=0.500000
+#EXT-X-PART:DURATION=0.600000,INDEPENDENT=YES,URI="a.0.m4s"
+`, "part-target"},
+		{"short part", `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=1.000000
+#EXT-X-PART-INF:PART-TARGET=0.500000
+#EXT-X-PART:DURATION=0.500000,INDEPENDENT=YES,URI="a.0.m4s"
+#EXT-X-PART:DURATION=0.200000,URI="a.1.m4s"
+#EXT-X-PART:DURATION=0.500000,URI="a.2.m4s"
+`, "part-target"},
+		{"part hold back", `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:2
+#EXT-X-SERVER-CONTROL:PART-HOLD-BACK=0.900000
+#EXT-X-PART-INF:PART-TARGET=0.500000
+`, "part-hold-back"},
+		{"date gap", `#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:00Z
+#EXTINF:2.000000,
+a.ts
+#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:03Z
+#EXTINF:2.000000,
+b.ts
+`, "program-date-time"},
+	} {
+		var pl m3u8.MediaPlaylist
+		if err := pl.UnmarshalText([]byte(tc.playlist)); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+		var r report
+		checkPlaylist(&r, "test.m3u8", &pl, defaultPDTTolerance)
+		if len(r.problems) != 1 || r.problems[0].rule != tc.rule {
+			t.Errorf("%s: expected one %s problem, got %v", tc.name, tc.rule, r.problems)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	parse := func(s string) *m3u8.MediaPlaylist {
+		pl := new(m3u8.MediaPlaylist)
+		if err := pl.UnmarshalText([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+		return pl
+	}
+	prev := parse(`#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:10
+#EXT-X-DISCONTINUITY
+#EXTINF:2.000000,
+a.ts
+#EXTINF:2.000000,
+b.ts
+`)
+	var r report
+	checkReload(&r, "test.m3u8", prev, parse(`#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:11
+#EXT-X-DISCONTINUITY-SEQUENCE:1
+#EXTINF:2.000000,
+b.ts
+#EXTINF:2.000000,
+c.ts
+`))
+	if len(r.problems) != 0 {
+		t.Errorf("unexpected problems: %v", r.problems)
+	}
+	checkReload(&r, "test.m3u8", prev, parse(`#EXTM3U
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:11
+#EXTINF:2.000000,
+c.ts
+`))
+	var rules []string
+	for _, p := range r.problems {
+		rules = append(rules, p.rule)
+	}
+	if got := strings.Join(rules, ","); got != "msn,discontinuity-sequence" {
+		t.Errorf("unexpected problems: %v", r.problems)
+	}
+}
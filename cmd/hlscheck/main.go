@@ -0,0 +1,59 @@
+// Command hlscheck fetches playlists from a live HLS publisher and checks them
+// against the rules of RFC 8216bis.
+//
+// Given a master playlist it checks each of the media playlists it refers to.
+// Each media playlist is reloaded several times, using blocking reloads if the
+// server supports them, and the playlist is compared with the previous one
+// after each reload. Media segments and parts are fetched once each to check
+// their structure.
+//
+// Problems are printed as they are found, prefixed with the name of the rule
+// that was broken. The exit status is 1 if there were any.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	c := &checker{client: http.DefaultClient}
+	flag.IntVar(&c.reloads, "reloads", 5, "number of times to reload each media playlist")
+	flag.BoolVar(&c.noBlock, "no-block", false, "don't use blocking playlist reloads")
+	flag.BoolVar(&c.noSegments, "no-segments", false, "don't fetch media segments")
+	flag.DurationVar(&c.pdtTolerance, "pdt-tolerance", defaultPDTTolerance, "allowed difference between EXT-X-PROGRAM-DATE-TIME and the end of the previous segment")
+	skip := flag.String("skip", "", "comma-separated rules to skip")
+	timeout := flag.Duration("timeout", 2*time.Minute, "give up after this long")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] URL\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c.report.skip = make(map[string]bool)
+	for _, rule := range strings.Split(*skip, ",") {
+		if rule != "" {
+			c.report.skip[rule] = true
+		}
+	}
+	c.report.out = os.Stdout
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := c.run(ctx, flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+	if n := len(c.report.problems); n != 0 {
+		fmt.Printf("%d problems found\n", n)
+		os.Exit(1)
+	}
+	fmt.Println("no problems found")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// problem is a broken rule
+type problem struct {
+	rule string
+	uri  string
+	msg  string
+}
+
+func (p problem) String() string {
+	return p.rule + ": " + p.uri + ": " + p.msg
+}
+
+// report collects the problems found, ignoring skipped rules and repeats
+type report struct {
+	skip     map[string]bool
+	out      io.Writer // optional
+	problems []problem
+	seen     map[problem]bool
+}
+
+func (r *report) add(rule, uri, format string, args ...interface{}) {
+	if r.skip[rule] {
+		return
+	}
+	p := problem{rule: rule, uri: uri, msg: fmt.Sprintf(format, args...)}
+	if r.seen[p] {
+		return
+	}
+	if r.seen == nil {
+		r.seen = make(map[problem]bool)
+	}
+	r.seen[p] = true
+	r.problems = append(r.problems, p)
+	if r.out != nil {
+		fmt.Fprintln(r.out, p)
+	}
+}
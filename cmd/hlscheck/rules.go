@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"eaglesong.dev/hls/m3u8"
+)
+
+const defaultPDTTolerance = 50 * time.Millisecond
+
+// check the rules that apply to a single media playlist
+func checkPlaylist(r *report, uri string, pl *m3u8.MediaPlaylist, pdtTolerance time.Duration) {
+	if pl.TargetDuration <= 0 {
+		r.add("target-duration", uri, "EXT-X-TARGETDURATION is missing")
+	}
+	var hasMap, hasParts bool
+	for i, seg := range pl.Segments {
+		msn := pl.MediaSequence + int64(i)
+		if seg.URI != "" && int(math.Round(seg.Duration.Seconds())) > pl.TargetDuration {
+			r.add("target-duration", uri, "segment %d is %s long, more than the target duration of %ds", msn, seg.Duration, pl.TargetDuration)
+		}
+		if seg.Map != nil {
+			hasMap = true
+		}
+		if len(seg.Parts) != 0 {
+			hasParts = true
+		}
+		checkParts(r, uri, pl, msn, seg)
+	}
+	if hasMap && pl.Version < 6 {
+		r.add("version", uri, "EXT-X-MAP requires version 6 but the playlist is version %d", pl.Version)
+	}
+	if hasMap && pl.Segments[0].Map == nil {
+		r.add("map", uri, "the first segment has no EXT-X-MAP but later ones do")
+	}
+	if hasParts && pl.PartTarget == 0 {
+		r.add("part-target", uri, "EXT-X-PART without EXT-X-PART-INF")
+	}
+	if pl.PartTarget != 0 && (pl.ServerControl == nil || pl.ServerControl.PartHoldBack == 0) {
+		r.add("part-hold-back", uri, "EXT-X-PART-INF without PART-HOLD-BACK")
+	} else if pl.PartTarget != 0 && pl.ServerControl.PartHoldBack < 2*pl.PartTarget {
+		r.add("part-hold-back", uri, "PART-HOLD-BACK of %s is less than twice the part target of %s", pl.ServerControl.PartHoldBack, pl.PartTarget)
+	}
+	if sc := pl.ServerControl; sc != nil && sc.HoldBack != 0 {
+		if target := time.Duration(pl.TargetDuration) * time.Second; sc.HoldBack < 3*target {
+			r.add("hold-back", uri, "HOLD-BACK of %s is less than three times the target duration of %s", sc.HoldBack, target)
+		}
+	}
+	checkProgramTime(r, uri, pl, pdtTolerance)
+}
+
+// check part durations against the part target
+func checkParts(r *report, uri string, pl *m3u8.MediaPlaylist, msn int64, seg m3u8.Segment) {
+	if pl.PartTarget == 0 {
+		return
+	}
+	for i, part := range seg.Parts {
+		if part.Duration > pl.PartTarget {
+			r.add("part-target", uri, "part %d.%d is %s long, more than the part target of %s", msn, i, part.Duration, pl.PartTarget)
+		}
+		// the last part of a segment may be short, and so may independent parts.
+		// if the segment is in progress then its last part might turn out to
+		// be the final one.
+		if i == len(seg.Parts)-1 || part.Independent {
+			continue
+		}
+		if part.Duration < pl.PartTarget*85/100 {
+			r.add("part-target", uri, "part %d.%d is %s long, less than 85%% of the part target of %s", msn, i, part.Duration, pl.PartTarget)
+		}
+	}
+}
+
+// check that each segment's program date-time follows on from the previous one
+func checkProgramTime(r *report, uri string, pl *m3u8.MediaPlaylist, tolerance time.Duration) {
+	var next time.Time
+	for i, seg := range pl.Segments {
+		if seg.Discontinuity {
+			next = time.Time{}
+		}
+		if !seg.ProgramDateTime.IsZero() {
+			if !next.IsZero() {
+				if diff := seg.ProgramDateTime.Sub(next); diff > tolerance || diff < -tolerance {
+					r.add("program-date-time", uri, "segment %d starts at %s but the previous segment ended at %s",
+						pl.MediaSequence+int64(i), seg.ProgramDateTime.Format(time.RFC3339Nano), next.Format(time.RFC3339Nano))
+				}
+			}
+			next = seg.ProgramDateTime
+		}
+		if !next.IsZero() {
+			next = next.Add(seg.Duration)
+		}
+	}
+}
+
+// check that a reloaded playlist is consistent with the previous version
+func checkReload(r *report, uri string, prev, cur *m3u8.MediaPlaylist) {
+	if cur.TargetDuration != prev.TargetDuration {
+		r.add("target-duration", uri, "EXT-X-TARGETDURATION changed from %d to %d", prev.TargetDuration, cur.TargetDuration)
+	}
+	if cur.MediaSequence < prev.MediaSequence {
+		r.add("msn", uri, "EXT-X-MEDIA-SEQUENCE went backwards from %d to %d", prev.MediaSequence, cur.MediaSequence)
+		return
+	}
+	if lastMSN(cur) < lastMSN(prev) {
+		r.add("msn", uri, "the last segment went backwards from %d to %d", lastMSN(prev), lastMSN(cur))
+	}
+	// segments with the same MSN must be the same segment
+	for msn := cur.MediaSequence; msn <= lastMSN(prev) && msn <= lastMSN(cur); msn++ {
+		before := prev.Segments[msn-prev.MediaSequence]
+		after := cur.Segments[msn-cur.MediaSequence]
+		if before.URI != "" && after.URI != "" {
+			if before.URI != after.URI {
+				r.add("msn", uri, "segment %d changed from %s to %s", msn, before.URI, after.URI)
+			} else if before.Duration != after.Duration {
+				r.add("msn", uri, "segment %d changed duration from %s to %s", msn, before.Duration, after.Duration)
+			}
+		}
+		if before.URI != "" && after.URI == "" {
+			r.add("msn", uri, "segment %d was complete but is now in progress", msn)
+		}
+		if before.Discontinuity != after.Discontinuity {
+			r.add("discontinuity-sequence", uri, "discontinuity before segment %d changed", msn)
+		}
+		for i := 0; i < len(before.Parts) && i < len(after.Parts); i++ {
+			if before.Parts[i].URI != after.Parts[i].URI {
+				r.add("msn", uri, "part %d.%d changed from %s to %s", msn, i, before.Parts[i].URI, after.Parts[i].URI)
+			}
+		}
+	}
+	// removing a discontinuity from the playlist increments the sequence
+	removed := cur.MediaSequence - prev.MediaSequence
+	if removed > int64(len(prev.Segments)) {
+		// reloaded too late to tell
+		return
+	}
+	expected := prev.DiscontinuitySequence
+	for _, seg := range prev.Segments[:removed] {
+		if seg.Discontinuity {
+			expected++
+		}
+	}
+	if cur.DiscontinuitySequence != expected {
+		r.add("discontinuity-sequence", uri, "EXT-X-DISCONTINUITY-SEQUENCE is %d after removing segments %d-%d, expected %d",
+			cur.DiscontinuitySequence, prev.MediaSequence, cur.MediaSequence-1, expected)
+	}
+}
+
+// MSN of the last segment, which may be in progress
+func lastMSN(pl *m3u8.MediaPlaylist) int64 {
+	return pl.MediaSequence + int64(len(pl.Segments)) - 1
+}
+
+// report whether a playlist contains the given segment, or the given part of it
+func hasPart(pl *m3u8.MediaPlaylist, msn int64, part int) bool {
+	last := lastMSN(pl)
+	if last > msn {
+		return true
+	} else if last < msn || len(pl.Segments) == 0 {
+		return false
+	}
+	seg := pl.Segments[len(pl.Segments)-1]
+	if seg.URI != "" {
+		return true
+	}
+	return part >= 0 && part < len(seg.Parts)
+}
+
+// the blocking reload parameters for the update following pl, or ok=false if
+// the server doesn't support blocking reloads
+func nextPart(pl *m3u8.MediaPlaylist) (msn int64, part int, ok bool) {
+	if pl.ServerControl == nil || !pl.ServerControl.CanBlockReload || pl.EndList || len(pl.Segments) == 0 {
+		return 0, 0, false
+	}
+	msn = lastMSN(pl)
+	if seg := pl.Segments[len(pl.Segments)-1]; seg.URI == "" {
+		// next part of the segment in progress
+		return msn, len(seg.Parts), true
+	}
+	if pl.PartTarget == 0 {
+		return msn + 1, -1, true
+	}
+	return msn + 1, 0, true
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+	"eaglesong.dev/hls/m3u8"
+)
+
+const tsPacketSize = 188
+
+// tracks declared by an fMP4 initialization segment
+type initSegment struct {
+	tracks map[uint32]bool
+}
+
+// fetch and check each segment and part of a playlist that hasn't been checked yet
+func (c *checker) checkSegments(ctx context.Context, base *url.URL, pl *m3u8.MediaPlaylist) error {
+	if pl.ImagesOnly {
+		return nil
+	}
+	var mapURI string
+	for _, seg := range pl.Segments {
+		if seg.Map != nil && seg.Map.ByteRange == "" {
+			mapURI = seg.Map.URI
+		}
+		for _, part := range seg.Parts {
+			if part.Gap || part.ByteRange != "" {
+				continue
+			}
+			if err := c.checkSegment(ctx, base, part.URI, mapURI); err != nil {
+				return err
+			}
+		}
+		if seg.URI != "" && !seg.Gap {
+			if err := c.checkSegment(ctx, base, seg.URI, mapURI); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// check the structure of a single segment or part
+func (c *checker) checkSegment(ctx context.Context, base *url.URL, ref, mapRef string) error {
+	u, err := base.Parse(ref)
+	if err != nil {
+		c.report.add("fetch", base.String(), "invalid URI %q", ref)
+		return nil
+	}
+	uri := u.String()
+	if c.checked[uri] {
+		return nil
+	}
+	if c.checked == nil {
+		c.checked = make(map[string]bool)
+	}
+	c.checked[uri] = true
+	blob, err := c.fetch(ctx, u)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.report.add("fetch", uri, "%s", err)
+		return nil
+	}
+	if len(blob) != 0 && blob[0] == 0x47 {
+		checkTS(&c.report, uri, blob)
+		return nil
+	}
+	var init *initSegment
+	if mapRef == "" {
+		c.report.add("map", uri, "fMP4 segment without EXT-X-MAP")
+	} else if init, err = c.loadInit(ctx, base, mapRef); err != nil {
+		return err
+	}
+	checkFragment(&c.report, uri, blob, init)
+	return nil
+}
+
+// fetch and check an initialization segment, returning nil if it is unusable
+func (c *checker) loadInit(ctx context.Context, base *url.URL, ref string) (*initSegment, error) {
+	u, err := base.Parse(ref)
+	if err != nil {
+		c.report.add("fetch", base.String(), "invalid URI %q", ref)
+		return nil, nil
+	}
+	uri := u.String()
+	if init, ok := c.inits[uri]; ok {
+		return init, nil
+	}
+	if c.inits == nil {
+		c.inits = make(map[string]*initSegment)
+	}
+	blob, err := c.fetch(ctx, u)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		c.report.add("fetch", uri, "%s", err)
+		c.inits[uri] = nil
+		return nil, nil
+	}
+	init := checkInit(&c.report, uri, blob)
+	c.inits[uri] = init
+	return init, nil
+}
+
+func checkInit(r *report, uri string, blob []byte) *initSegment {
+	atoms, err := fmp4io.ReadFileAtoms(bytes.NewReader(blob))
+	if err != nil {
+		r.add("fmp4", uri, "%s", err)
+		return nil
+	}
+	if len(atoms) == 0 || atoms[0].Tag() != fmp4io.FTYP {
+		r.add("fmp4", uri, "initialization segment doesn't begin with ftyp")
+	}
+	var moov *fmp4io.Movie
+	for _, atom := range atoms {
+		switch atom.Tag() {
+		case fmp4io.MOOV:
+			moov = atom.(*fmp4io.Movie)
+		case fmp4io.MOOF:
+			r.add("fmp4", uri, "initialization segment contains a moof")
+		}
+	}
+	if moov == nil {
+		r.add("fmp4", uri, "initialization segment has no moov")
+		return nil
+	}
+	if moov.MovieExtend == nil {
+		r.add("fmp4", uri, "moov has no mvex")
+	}
+	init := &initSegment{tracks: make(map[uint32]bool)}
+	for _, track := range moov.Tracks {
+		if track.Header != nil {
+			init.tracks[track.Header.TrackID] = true
+		}
+	}
+	return init
+}
+
+// check a media segment or part. If init is not nil then the fragments must
+// only refer to its tracks.
+func checkFragment(r *report, uri string, blob []byte, init *initSegment) {
+	atoms, err := fmp4io.ReadFileAtoms(bytes.NewReader(blob))
+	if err != nil {
+		r.add("fmp4", uri, "%s", err)
+		return
+	}
+	var fragments int
+	for i, atom := range atoms {
+		switch atom.Tag() {
+		case fmp4io.MOOV:
+			r.add("fmp4", uri, "media segment contains a moov")
+		case fmp4io.MOOF:
+			fragments++
+			offset, _ := atom.Pos()
+			if i+1 == len(atoms) || atoms[i+1].Tag() != fmp4io.MDAT {
+				r.add("fmp4", uri, "moof at offset %d isn't followed by mdat", offset)
+			}
+			moof := atom.(*fmp4io.MovieFrag)
+			if moof.Header == nil {
+				r.add("fmp4", uri, "moof at offset %d has no mfhd", offset)
+			}
+			if len(moof.Tracks) == 0 {
+				r.add("fmp4", uri, "moof at offset %d has no traf", offset)
+			}
+			for _, traf := range moof.Tracks {
+				if traf.Header == nil {
+					r.add("fmp4", uri, "traf in moof at offset %d has no tfhd", offset)
+					continue
+				}
+				trackID := traf.Header.TrackID
+				if init != nil && !init.tracks[trackID] {
+					r.add("fmp4", uri, "traf for track %d isn't in the initialization segment", trackID)
+				}
+				if traf.DecodeTime == nil {
+					r.add("fmp4", uri, "traf for track %d has no tfdt", trackID)
+				}
+			}
+		}
+	}
+	if fragments == 0 {
+		r.add("fmp4", uri, "media segment has no moof")
+	}
+}
+
+// check that a transport stream segment is made of whole packets
+func checkTS(r *report, uri string, blob []byte) {
+	if len(blob)%tsPacketSize != 0 {
+		r.add("ts", uri, "size %d is not a multiple of %d", len(blob), tsPacketSize)
+	}
+	for i := 0; i < len(blob); i += tsPacketSize {
+		if blob[i] != 0x47 {
+			r.add("ts", uri, "lost sync at offset %d", i)
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Command mp4dump prints the box structure of fragmented MP4 files, such as the
+// initialization and media segments served by a publisher.
+//
+// Files are read in order, and the tracks declared by an initialization segment
+// are used to interpret the fragments of the files that follow it, so the
+// initialization segment should be given first:
+//
+//	mp4dump init.mp4 0.m4s 1.m4s
+//
+// With -samples, the samples of each track fragment are listed after the box
+// tree with their offsets, sizes, timestamps and flags. With -json, the samples
+// are printed as JSON instead of the box tree.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+)
+
+func main() {
+	samples := flag.Bool("samples", false, "list the samples of each track fragment")
+	asJSON := flag.Bool("json", false, "print the samples as JSON instead of the box tree")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	tracks := make(map[uint32]*trackInfo)
+	var files []fileSamples
+	for _, name := range flag.Args() {
+		atoms, err := readFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+		fs := fileSamples{File: name, Fragments: []fragmentSamples{}}
+		for _, atom := range atoms {
+			switch atom := atom.(type) {
+			case *fmp4io.Movie:
+				readTracks(atom, tracks)
+			case *fmp4io.MovieFrag:
+				fs.Fragments = append(fs.Fragments, listSamples(atom, tracks)...)
+			}
+		}
+		if *asJSON {
+			files = append(files, fs)
+			continue
+		}
+		if flag.NArg() > 1 {
+			fmt.Printf("%s:\n", name)
+		}
+		for _, atom := range atoms {
+			fmp4io.FprintAtom(os.Stdout, atom)
+		}
+		if *samples {
+			for _, frag := range fs.Fragments {
+				frag.print(os.Stdout)
+			}
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(files); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func readFile(name string) ([]fmp4io.Atom, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	atoms, err := fmp4io.ReadFileAtoms(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return atoms, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+)
+
+// what an initialization segment says about a track
+type trackInfo struct {
+	timeScale uint32
+	defaults  *fmp4io.TrackExtend // optional
+}
+
+type fileSamples struct {
+	File      string            `json:"file"`
+	Fragments []fragmentSamples `json:"fragments"`
+}
+
+// samples of one track fragment
+type fragmentSamples struct {
+	Sequence       uint32   `json:"sequence"`
+	TrackID        uint32   `json:"track_id"`
+	TimeScale      uint32   `json:"timescale,omitempty"`
+	BaseDecodeTime uint64   `json:"base_decode_time"`
+	Samples        []sample `json:"samples"`
+}
+
+type sample struct {
+	Offset   int64   `json:"offset"`
+	Size     uint32  `json:"size"`
+	DTS      uint64  `json:"dts"`
+	PTS      int64   `json:"pts"`
+	Duration uint32  `json:"duration"`
+	Time     float64 `json:"time"` // PTS in seconds, or 0 if the timescale is unknown
+	Flags    uint32  `json:"flags"`
+	Sync     bool    `json:"sync"`
+}
+
+// record the timescale and sample defaults of each track of an initialization segment
+func readTracks(moov *fmp4io.Movie, tracks map[uint32]*trackInfo) {
+	for _, track := range moov.Tracks {
+		if track.Header == nil {
+			continue
+		}
+		info := new(trackInfo)
+		if track.Media != nil && track.Media.Header != nil {
+			info.timeScale = track.Media.Header.TimeScale
+		}
+		tracks[track.Header.TrackID] = info
+	}
+	if moov.MovieExtend == nil {
+		return
+	}
+	for _, trex := range moov.MovieExtend.Tracks {
+		if info := tracks[trex.TrackID]; info != nil {
+			info.defaults = trex
+		}
+	}
+}
+
+// work out the position and timing of each sample in a movie fragment
+func listSamples(moof *fmp4io.MovieFrag, tracks map[uint32]*trackInfo) []fragmentSamples {
+	var seqNum uint32
+	if moof.Header != nil {
+		seqNum = moof.Header.Seqnum
+	}
+	moofOffset, _ := moof.Pos()
+	// without an explicit base, each track's data follows the previous track's
+	dataEnd := int64(moofOffset)
+	var frags []fragmentSamples
+	for _, traf := range moof.Tracks {
+		tfhd := traf.Header
+		if tfhd == nil {
+			continue
+		}
+		frag := fragmentSamples{
+			Sequence: seqNum,
+			TrackID:  tfhd.TrackID,
+			Samples:  []sample{},
+		}
+		var duration, size uint32
+		var flags fmp4io.SampleFlags
+		if info := tracks[tfhd.TrackID]; info != nil {
+			frag.TimeScale = info.timeScale
+			if trex := info.defaults; trex != nil {
+				duration = trex.DefaultSampleDuration
+				size = trex.DefaultSampleSize
+				flags = fmp4io.SampleFlags(trex.DefaultSampleFlags)
+			}
+		}
+		if tfhd.Flags&fmp4io.TrackFragDefaultDuration != 0 {
+			duration = tfhd.DefaultDuration
+		}
+		if tfhd.Flags&fmp4io.TrackFragDefaultSize != 0 {
+			size = tfhd.DefaultSize
+		}
+		if tfhd.Flags&fmp4io.TrackFragDefaultFlags != 0 {
+			flags = tfhd.DefaultFlags
+		}
+		base := dataEnd
+		if tfhd.Flags&fmp4io.TrackFragBaseDataOffset != 0 {
+			base = int64(tfhd.BaseDataOffset)
+		} else if tfhd.Flags&fmp4io.TrackFragDefaultBaseIsMOOF != 0 {
+			base = int64(moofOffset)
+		}
+		if traf.DecodeTime != nil {
+			frag.BaseDecodeTime = traf.DecodeTime.Time
+		}
+		if trun := traf.Run; trun != nil {
+			offset := base
+			if trun.Flags&fmp4io.TrackRunDataOffset != 0 {
+				offset += int64(int32(trun.DataOffset))
+			}
+			dts := frag.BaseDecodeTime
+			for i, entry := range trun.Entries {
+				s := sample{
+					Offset:   offset,
+					Size:     size,
+					DTS:      dts,
+					Duration: duration,
+				}
+				sampleFlags := flags
+				if trun.Flags&fmp4io.TrackRunSampleDuration != 0 {
+					s.Duration = entry.Duration
+				}
+				if trun.Flags&fmp4io.TrackRunSampleSize != 0 {
+					s.Size = entry.Size
+				}
+				if trun.Flags&fmp4io.TrackRunSampleFlags != 0 {
+					sampleFlags = entry.Flags
+				} else if i == 0 && trun.Flags&fmp4io.TrackRunFirstSampleFlags != 0 {
+					sampleFlags = trun.FirstSampleFlags
+				}
+				s.PTS = int64(dts)
+				if trun.Flags&fmp4io.TrackRunSampleCTS != 0 {
+					s.PTS += int64(entry.CTS)
+				}
+				if frag.TimeScale != 0 {
+					s.Time = float64(s.PTS) / float64(frag.TimeScale)
+				}
+				s.Flags = uint32(sampleFlags)
+				s.Sync = sampleFlags.IsSync()
+				frag.Samples = append(frag.Samples, s)
+				offset += int64(s.Size)
+				dts += uint64(s.Duration)
+			}
+			dataEnd = offset
+		}
+		frags = append(frags, frag)
+	}
+	return frags
+}
+
+func (f fragmentSamples) print(w io.Writer) {
+	fmt.Fprintf(w, "fragment seq=%d track=%d basetime=%d", f.Sequence, f.TrackID, f.BaseDecodeTime)
+	if f.TimeScale != 0 {
+		fmt.Fprintf(w, " timescale=%d", f.TimeScale)
+	}
+	fmt.Fprintln(w)
+	for i, s := range f.Samples {
+		fmt.Fprintf(w, "  %4d offset=%d size=%d dts=%d pts=%d duration=%d", i, s.Offset, s.Size, s.DTS, s.PTS, s.Duration)
+		if f.TimeScale != 0 {
+			fmt.Fprintf(w, " time=%.6f", s.Time)
+		}
+		fmt.Fprintf(w, " flags=(%s)\n", fmp4io.SampleFlags(s.Flags))
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls/internal/fmp4"
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+)
+
+func TestListSamples(t *testing.T) {
+	cd := aacparser.CodecData{Config: aacparser.MPEG4AudioConfig{SampleRate: 48000, ChannelLayout: av.CH_STEREO, ObjectType: 2}}
+	f, err := fmp4.NewTrack(cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := 10 * time.Second
+	for i := 0; i < 11; i++ {
+		pkt := av.Packet{Time: start + time.Duration(i)*21333*time.Microsecond, Data: bytes.Repeat([]byte{byte(i)}, 100+i)}
+		if err := f.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frag, err := f.Fragment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := make(map[uint32]*trackInfo)
+	atoms, err := fmp4io.ReadFileAtoms(bytes.NewReader(f.Header().HeaderContents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, atom := range atoms {
+		if moov, ok := atom.(*fmp4io.Movie); ok {
+			readTracks(moov, tracks)
+		}
+	}
+	atoms, err = fmp4io.ReadFileAtoms(bytes.NewReader(frag.Bytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var frags []fragmentSamples
+	for _, atom := range atoms {
+		if moof, ok := atom.(*fmp4io.MovieFrag); ok {
+			frags = append(frags, listSamples(moof, tracks)...)
+		}
+	}
+	if len(frags) != 1 {
+		t.Fatalf("expected 1 fragment, got %d", len(frags))
+	}
+	samples := frags[0].Samples
+	// the last packet is held back until the next one gives its duration
+	if len(samples) != 10 {
+		t.Fatalf("expected 10 samples, got %d", len(samples))
+	}
+	if frags[0].TimeScale == 0 {
+		t.Error("timescale from the initialization segment is missing")
+	}
+	for i, s := range samples {
+		if int(s.Size) != 100+i {
+			t.Errorf("sample %d: expected size %d, got %d", i, 100+i, s.Size)
+		}
+		data := frag.Bytes[s.Offset : s.Offset+int64(s.Size)]
+		if !bytes.Equal(data, bytes.Repeat([]byte{byte(i)}, 100+i)) {
+			t.Errorf("sample %d: offset %d doesn't point at its data", i, s.Offset)
+		}
+		expected := (start + time.Duration(i)*21333*time.Microsecond).Seconds()
+		if diff := s.Time - expected; diff > 0.0001 || diff < -0.0001 {
+			t.Errorf("sample %d: expected time %f, got %f", i, expected, s.Time)
+		}
+		if !s.Sync {
+			t.Errorf("sample %d: audio should be sync", i)
+		}
+	}
+}
@@ -5,24 +5,55 @@ import (
 	"encoding/hex"
 	"encoding/xml"
 	"fmt"
+	"strconv"
 	"time"
 
-	"eaglesong.dev/hls/internal/dashmpd"
+	"eaglesong.dev/hls/dashmpd"
+	"eaglesong.dev/hls/internal/naming"
+	"eaglesong.dev/hls/internal/segment"
 	"eaglesong.dev/hls/internal/timescale"
 	"github.com/nareix/joy4/av"
 )
 
+const (
+	defaultMinPlaybackRate = 0.96
+	defaultMaxPlaybackRate = 1.04
+)
+
+// LatencyTarget asks low-latency DASH players to keep their distance to the live edge within a range
+type LatencyTarget struct {
+	// Target is the preferred latency. Min and Max optionally bound it.
+	Target, Min, Max time.Duration
+	// MinPlaybackRate and MaxPlaybackRate limit how much the player may slow
+	// down or speed up to reach the target. Default to 0.96 and 1.04.
+	MinPlaybackRate, MaxPlaybackRate float64
+}
+
+// a DASH period, which begins at each discontinuity
+type dashPeriod struct {
+	id    string
+	msn   segment.MSN   // first segment in the period
+	first time.Duration // timestamp of the first segment
+	start time.Duration // presentation time at which the period begins
+	asets []dashmpd.AdaptationSet
+}
+
 // populate a DASH MPD from codec data
 func (p *Publisher) initMPD() {
+	ast := time.Now().UTC().Truncate(time.Millisecond)
+	if !p.Epoch.IsZero() {
+		// segments are timed relative to the epoch
+		ast = p.Epoch.UTC()
+	}
 	p.mpd = dashmpd.MPD{
 		ID:                    "m" + p.pid,
 		Profiles:              "urn:mpeg:dash:profile:isoff-live:2011",
 		Type:                  "dynamic",
 		MinBufferTime:         dashmpd.Duration{Duration: 1000 * time.Millisecond},
-		AvailabilityStartTime: time.Now().UTC().Truncate(time.Millisecond),
-		MaxSegmentDuration:    dashmpd.Duration{Duration: p.InitialDuration},
-		TimeShiftBufferDepth:  dashmpd.Duration{Duration: p.BufferLength},
-		Period:                []dashmpd.Period{{ID: "p0"}},
+		AvailabilityStartTime: &ast,
+		MinimumUpdatePeriod:   &dashmpd.Duration{},
+		MaxSegmentDuration:    &dashmpd.Duration{Duration: p.InitialDuration},
+		TimeShiftBufferDepth:  &dashmpd.Duration{Duration: p.BufferLength},
 		UTCTiming: &dashmpd.UTCTiming{
 			Scheme: "urn:mpeg:dash:utc:http-xsdate:2014",
 			Value:  "time",
@@ -34,20 +65,76 @@ func (p *Publisher) initMPD() {
 	if p.mpd.TimeShiftBufferDepth.Duration == 0 {
 		p.mpd.TimeShiftBufferDepth.Duration = defaultBufferLength
 	}
+	if lt := p.DASHLatency; lt != nil {
+		rate := &dashmpd.PlaybackRate{Min: lt.MinPlaybackRate, Max: lt.MaxPlaybackRate}
+		if rate.Min <= 0 {
+			rate.Min = defaultMinPlaybackRate
+		}
+		if rate.Max <= 0 {
+			rate.Max = defaultMaxPlaybackRate
+		}
+		p.mpd.ServiceDescription = &dashmpd.ServiceDescription{
+			Latency: &dashmpd.Latency{
+				Target: lt.Target.Milliseconds(),
+				Min:    lt.Min.Milliseconds(),
+				Max:    lt.Max.Milliseconds(),
+			},
+			PlaybackRate: rate,
+		}
+	}
+	// the first period begins with the first segment
+	p.nextPeriod = true
+}
+
+// start a new period using the current codec data. first is the timestamp of
+// the period's first segment, which is now the last one in the track.
+func (p *Publisher) addPeriod(msn segment.MSN, first time.Duration) {
+	period := &dashPeriod{
+		id:    fmt.Sprintf("p%d", msn),
+		msn:   msn,
+		first: first,
+	}
+	if len(p.periods) == 0 {
+		// first period begins when the first segment did
+		period.start = p.firstWall
+	} else if i := p.lastMedia(int(msn-p.baseMSN) - 1); i >= 0 {
+		// begin where the previous period's last segment ended. timestamps may
+		// have jumped, so they can't be compared across periods.
+		prev := p.periods[len(p.periods)-1]
+		seg := p.primary.segments[i]
+		dur := seg.Duration()
+		if dur == 0 {
+			dur = p.targetDuration()
+		}
+		period.start = prev.start + seg.Start() + dur - prev.first
+	}
+	media := "$Number$"
+	if p.DASHTimeAddressing {
+		media = "t$Time$"
+	}
 	for trackID, cd := range p.streams {
 		t := p.tracks[trackID]
-		aset := adaptationSet(cd, p.tracks[trackID].codecTag)
-		aset.SegmentTemplate = dashmpd.SegmentTemplate{
-			Timescale:       int(t.frag.TimeScale()),
-			Media:           fmt.Sprintf("%d%s$Number$.m4s", trackID, p.pid),
-			StartNumber:     0,
-			SegmentTimeline: new(dashmpd.SegmentTimeline),
+		aset := adaptationSet(cd, t.codecTag)
+		aset.ID = strconv.Itoa(trackID)
+		aset.SegmentTemplate = &dashmpd.SegmentTemplate{
+			Timescale:   dashmpd.Int(int(t.frag.TimeScale())),
+			Media:       p.names.Format(naming.Name{Track: trackID, MSN: media, Part: -1, Ext: t.hdr.SegmentExtension}),
+			StartNumber: dashmpd.Int(int(msn)),
 		}
-		if filename := t.hdr.HeaderName; filename != "" {
-			aset.SegmentTemplate.Initialization = fmt.Sprintf("%d%s%s", trackID, p.pid, filename)
+		if filename := t.headerName(msn); filename != "" {
+			aset.SegmentTemplate.Initialization = filename
 		}
-		p.mpd.Period[0].AdaptationSet = append(p.mpd.Period[0].AdaptationSet, aset)
+		period.asets = append(period.asets, aset)
 	}
+	p.periods = append(p.periods, period)
+}
+
+// find the last segment at or before index i that isn't a gap
+func (p *Publisher) lastMedia(i int) int {
+	for i >= 0 && p.primary.segments[i].Gap() {
+		i--
+	}
+	return i
 }
 
 // update MPD with current set of available segments
@@ -59,38 +146,104 @@ func (p *Publisher) updateMPD(initialDur time.Duration) cachedMPD {
 	if fragLen <= 0 {
 		fragLen = defaultFragmentLength
 	}
-	p.mpd.PublishTime = time.Now().UTC().Round(time.Second)
-	p.mpd.MaxSegmentDuration = dashmpd.Duration{Duration: initialDur}
-	for trackID := range p.streams {
-		p.updateMPDTrack(trackID, initialDur, fragLen)
+	// each version needs a distinct publishTime to be patched from
+	published := time.Now().UTC().Truncate(time.Millisecond)
+	p.mpd.PublishTime = &published
+	p.mpd.MaxSegmentDuration = &dashmpd.Duration{Duration: initialDur}
+	// expire periods whose segments have all been trimmed
+	for len(p.periods) > 1 && p.periods[1].msn <= p.baseMSN {
+		p.periods = p.periods[1:]
+	}
+	p.mpd.Period = make([]dashmpd.Period, len(p.periods))
+	for i, period := range p.periods {
+		// find the range of segments belonging to this period
+		first := int(period.msn - p.baseMSN)
+		if first < 0 {
+			first = 0
+		}
+		last := len(p.primary.segments)
+		if i+1 < len(p.periods) {
+			last = int(p.periods[i+1].msn - p.baseMSN)
+		}
+		for first < last && p.primary.segments[first].Gap() {
+			// gaps are only numbered by HLS
+			first++
+		}
+		mp := &p.mpd.Period[i]
+		mp.ID = period.id
+		mp.Start = &dashmpd.Duration{Duration: period.start}
+		mp.AdaptationSet = make([]dashmpd.AdaptationSet, len(period.asets))
+		copy(mp.AdaptationSet, period.asets)
+		for trackID := range p.streams {
+			aset := &mp.AdaptationSet[trackID]
+			aset.Representation = append([]dashmpd.Representation(nil), aset.Representation...)
+			tmpl := *aset.SegmentTemplate
+			aset.SegmentTemplate = &tmpl
+			aset.SegmentTemplate.StartNumber = dashmpd.Int(int(p.baseMSN) + first)
+			aset.SegmentTemplate.PresentationTimeOffset = timescale.ToScale(period.first, p.tracks[trackID].frag.TimeScale())
+			p.updateMPDTrack(aset, trackID, first, last, initialDur, fragLen)
+		}
+		lastMSN := segment.MSN(-1)
+		if i+1 < len(p.periods) {
+			lastMSN = p.periods[i+1].msn
+		}
+		if aset := p.imageAdaptationSet(period.msn, lastMSN, period.first); aset != nil {
+			mp.AdaptationSet = append(mp.AdaptationSet, *aset)
+		}
+	}
+	if p.PatchMPD {
+		p.setPatchLocation(initialDur)
 	}
 	blob, _ := xml.Marshal(p.mpd)
 	blob = append([]byte(xml.Header), blob...)
 	d := sha256.New()
 	d.Write(blob)
-	return cachedMPD{
+	cached := cachedMPD{
 		etag:  "\"" + hex.EncodeToString(d.Sum(nil)[:16]) + "\"",
 		value: blob,
 	}
+	if p.PatchMPD {
+		cached.published = formatPublishTime(published)
+		cached.patches = p.updateMPDPatches()
+	}
+	return cached
 }
 
-// update MPD with a single track's segments
-func (p *Publisher) updateMPDTrack(trackID int, initialDur, fragLen time.Duration) {
+// update a period's adaptation set with a single track's segments
+func (p *Publisher) updateMPDTrack(aset *dashmpd.AdaptationSet, trackID, first, last int, initialDur, fragLen time.Duration) {
 	track := p.tracks[trackID]
 	var totalSize int64
 	var totalDur float64
 	timeScale := track.frag.TimeScale()
-	aset := &p.mpd.Period[0].AdaptationSet[trackID]
-	aset.SegmentTemplate.StartNumber = int(p.baseMSN)
-	aset.SegmentTemplate.AvailabilityTimeComplete = "false"
-	aset.SegmentTemplate.AvailabilityTimeOffset = (initialDur - fragLen).Seconds()
+	if fragLen < initialDur {
+		// segments are published chunk by chunk, so the first chunk can be
+		// requested as soon as it is ready and the rest arrive as they are
+		// produced. each chunk begins with a MOOF that a client can resync to.
+		aset.SegmentTemplate.AvailabilityTimeComplete = "false"
+		aset.SegmentTemplate.AvailabilityTimeOffset = (initialDur - fragLen).Seconds()
+		aset.Resync = &dashmpd.Resync{DT: int(timescale.ToScale(fragLen, timeScale)), Marker: true}
+	}
+	if first < last {
+		if seg := track.segments[first]; !seg.ProgramTime().IsZero() {
+			// matches the prft box at the start of the segment
+			aset.ProducerReferenceTime = &dashmpd.ProducerReferenceTime{
+				Type:             "captured",
+				WallClockTime:    seg.ProgramTime(),
+				PresentationTime: timescale.ToScale(seg.Start(), timeScale),
+				UTCTiming:        p.mpd.UTCTiming,
+			}
+		}
+	}
 	if trackID == p.vidx {
-		aset.MaxFrameRate = p.rate.Rate()
+		aset.MaxFrameRate = dashmpd.FrameRate(p.rate.Rate())
 		aset.Representation[0].FrameRate = aset.MaxFrameRate
 	}
-	tl := aset.SegmentTemplate.SegmentTimeline
-	tl.Segments = tl.Segments[:0]
-	for i, seg := range track.segments {
+	tl := new(dashmpd.SegmentTimeline)
+	aset.SegmentTemplate.SegmentTimeline = tl
+	for _, seg := range track.segments[first:last] {
+		if seg.Gap() {
+			continue
+		}
 		start := seg.Start()
 		startDTS := timescale.ToScale(seg.Start(), timeScale)
 		dur := seg.Duration()
@@ -107,7 +260,7 @@ func (p *Publisher) updateMPDTrack(trackID int, initialDur, fragLen time.Duratio
 			tl.Segments[prev].Repeat++
 		} else {
 			seg := dashmpd.Segment{Duration: durTS}
-			if i == 0 {
+			if len(tl.Segments) == 0 {
 				// first segment has absolute time
 				seg.Time = startDTS
 			}
@@ -122,6 +275,9 @@ func (p *Publisher) updateMPDTrack(trackID int, initialDur, fragLen time.Duratio
 type cachedMPD struct {
 	etag  string
 	value []byte
+	// patches to this version, keyed by the publishTime of the version they apply to
+	published string
+	patches   map[string][]byte
 }
 
 func adaptationSet(cd av.CodecData, codecTag string) dashmpd.AdaptationSet {
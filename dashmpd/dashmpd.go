@@ -0,0 +1,289 @@
+// Package dashmpd models a DASH Media Presentation Description (MPD) for
+// marshalling and unmarshalling with encoding/xml.
+//
+// Optional attributes are pointers or omitted when empty so that an MPD read
+// from elsewhere is written back out with the same meaning.
+package dashmpd
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Namespace is the XML namespace of MPD documents
+const Namespace = "urn:mpeg:dash:schema:mpd:2011"
+
+type MPD struct {
+	XMLName  xml.Name `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	ID       string   `xml:"id,attr,omitempty"`
+	Profiles string   `xml:"profiles,attr"`
+	Type     string   `xml:"type,attr,omitempty"`
+
+	AvailabilityStartTime      *time.Time `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime                *time.Time `xml:"publishTime,attr,omitempty"`
+	MediaPresentationDuration  *Duration  `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinimumUpdatePeriod        *Duration  `xml:"minimumUpdatePeriod,attr,omitempty"`
+	MaxSegmentDuration         *Duration  `xml:"maxSegmentDuration,attr,omitempty"`
+	MinBufferTime              Duration   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth       *Duration  `xml:"timeShiftBufferDepth,attr,omitempty"`
+	SuggestedPresentationDelay *Duration  `xml:"suggestedPresentationDelay,attr,omitempty"`
+
+	BaseURL              []BaseURL
+	Location             []string
+	PatchLocation        *PatchLocation
+	ServiceDescription   *ServiceDescription
+	Period               []Period
+	EssentialProperty    []Descriptor
+	SupplementalProperty []Descriptor
+	UTCTiming            *UTCTiming
+}
+
+// BaseURL is a URL that relative URLs in its parent element are resolved against
+type BaseURL struct {
+	ServiceLocation string `xml:"serviceLocation,attr,omitempty"`
+	ByteRange       string `xml:"byteRange,attr,omitempty"`
+	URL             string `xml:",chardata"`
+}
+
+// ServiceDescription tells low-latency players how to pace playback
+type ServiceDescription struct {
+	ID           int `xml:"id,attr"`
+	Latency      *Latency
+	PlaybackRate *PlaybackRate
+}
+
+// Latency to the live edge, in milliseconds
+type Latency struct {
+	ReferenceID int   `xml:"referenceId,attr"`
+	Target      int64 `xml:"target,attr,omitempty"`
+	Min         int64 `xml:"min,attr,omitempty"`
+	Max         int64 `xml:"max,attr,omitempty"`
+}
+
+type PlaybackRate struct {
+	Min float64 `xml:"min,attr"`
+	Max float64 `xml:"max,attr"`
+}
+
+type Period struct {
+	ID       string    `xml:"id,attr,omitempty"`
+	Start    *Duration `xml:"start,attr,omitempty"`
+	Duration *Duration `xml:"duration,attr,omitempty"`
+
+	BaseURL              []BaseURL
+	SegmentBase          *SegmentBase
+	SegmentTemplate      *SegmentTemplate
+	EventStream          []EventStream
+	AdaptationSet        []AdaptationSet
+	SupplementalProperty []Descriptor
+}
+
+// EventStream carries timed events, such as ad markers, in the MPD itself
+type EventStream struct {
+	SchemeID               string `xml:"schemeIdUri,attr"`
+	Value                  string `xml:"value,attr,omitempty"`
+	Timescale              int    `xml:"timescale,attr,omitempty"`
+	PresentationTimeOffset uint64 `xml:"presentationTimeOffset,attr,omitempty"`
+
+	Event []Event
+}
+
+// Event is a single event in an EventStream, with times in the stream's timescale
+type Event struct {
+	PresentationTime uint64 `xml:"presentationTime,attr,omitempty"`
+	Duration         uint64 `xml:"duration,attr,omitempty"`
+	ID               string `xml:"id,attr,omitempty"`
+	MessageData      string `xml:"messageData,attr,omitempty"`
+	Content          string `xml:",chardata"`
+}
+
+type AdaptationSet struct {
+	ID               string    `xml:"id,attr,omitempty"`
+	ContentType      string    `xml:"contentType,attr,omitempty"`
+	Lang             string    `xml:"lang,attr,omitempty"`
+	MimeType         string    `xml:"mimeType,attr,omitempty"`
+	Codecs           string    `xml:"codecs,attr,omitempty"`
+	SegmentAlignment bool      `xml:"segmentAlignment,attr,omitempty"`
+	StartWithSAP     int       `xml:"startWithSAP,attr,omitempty"`
+	MaxFrameRate     FrameRate `xml:"maxFrameRate,attr,omitempty"`
+	MaxWidth         int       `xml:"maxWidth,attr,omitempty"`
+	MaxHeight        int       `xml:"maxHeight,attr,omitempty"`
+	PAR              string    `xml:"par,attr,omitempty"`
+
+	ContentProtection     []ContentProtection
+	EssentialProperty     []Descriptor
+	SupplementalProperty  []Descriptor
+	Label                 []Label
+	ProducerReferenceTime *ProducerReferenceTime
+	Resync                *Resync
+	Accessibility         []Descriptor
+	Role                  []Descriptor
+	BaseURL               []BaseURL
+	SegmentBase           *SegmentBase
+	SegmentTemplate       *SegmentTemplate
+	Representation        []Representation
+}
+
+// Label is a human-readable description of an adaptation set
+type Label struct {
+	ID   int    `xml:"id,attr,omitempty"`
+	Lang string `xml:"lang,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+// ProducerReferenceTime relates a presentation time to the wall-clock time at which it was produced
+type ProducerReferenceTime struct {
+	ID               int       `xml:"id,attr"`
+	Type             string    `xml:"type,attr,omitempty"`
+	WallClockTime    time.Time `xml:"wallClockTime,attr"`
+	PresentationTime uint64    `xml:"presentationTime,attr"`
+
+	UTCTiming *UTCTiming
+}
+
+// Resync describes where a client can begin parsing partway through a segment
+type Resync struct {
+	Type   int  `xml:"type,attr"`
+	DT     int  `xml:"dT,attr,omitempty"`
+	Marker bool `xml:"marker,attr,omitempty"`
+}
+
+// SegmentBase locates the segments of a single-file representation by its index
+type SegmentBase struct {
+	Timescale              int    `xml:"timescale,attr,omitempty"`
+	PresentationTimeOffset uint64 `xml:"presentationTimeOffset,attr,omitempty"`
+	IndexRange             string `xml:"indexRange,attr,omitempty"`
+	IndexRangeExact        bool   `xml:"indexRangeExact,attr,omitempty"`
+
+	Initialization      *URL
+	RepresentationIndex *URL
+}
+
+// URL refers to a resource or a byte range of one
+type URL struct {
+	SourceURL string `xml:"sourceURL,attr,omitempty"`
+	Range     string `xml:"range,attr,omitempty"`
+}
+
+// SegmentTemplate locates segments by filling in a URL template. It may appear
+// at the Period, AdaptationSet and Representation levels, and attributes that
+// are absent at one level are inherited from the level above. Use
+// ResolveTemplate to get the values that apply to a representation.
+type SegmentTemplate struct {
+	Duration       int    `xml:"duration,attr,omitempty"`
+	Initialization string `xml:"initialization,attr,omitempty"`
+	Media          string `xml:"media,attr,omitempty"`
+	StartNumber    *int   `xml:"startNumber,attr,omitempty"`
+	Timescale      *int   `xml:"timescale,attr,omitempty"`
+
+	PresentationTimeOffset uint64 `xml:"presentationTimeOffset,attr,omitempty"`
+
+	AvailabilityTimeComplete string  `xml:"availabilityTimeComplete,attr,omitempty"`
+	AvailabilityTimeOffset   float64 `xml:"availabilityTimeOffset,attr,omitempty"`
+
+	SegmentTimeline *SegmentTimeline
+}
+
+// ResolveTemplate combines the templates of each level of the hierarchy, from
+// the outermost to the innermost, into the one that applies at the innermost
+// level. Nil templates are skipped. startNumber and timescale default to 1 if
+// no level sets them. It returns nil if all of the templates are nil.
+func ResolveTemplate(templates ...*SegmentTemplate) *SegmentTemplate {
+	var res *SegmentTemplate
+	for _, t := range templates {
+		if t == nil {
+			continue
+		} else if res == nil {
+			res = new(SegmentTemplate)
+		}
+		if t.Duration != 0 {
+			res.Duration = t.Duration
+		}
+		if t.Initialization != "" {
+			res.Initialization = t.Initialization
+		}
+		if t.Media != "" {
+			res.Media = t.Media
+		}
+		if t.StartNumber != nil {
+			res.StartNumber = t.StartNumber
+		}
+		if t.Timescale != nil {
+			res.Timescale = t.Timescale
+		}
+		if t.PresentationTimeOffset != 0 {
+			res.PresentationTimeOffset = t.PresentationTimeOffset
+		}
+		if t.AvailabilityTimeComplete != "" {
+			res.AvailabilityTimeComplete = t.AvailabilityTimeComplete
+		}
+		if t.AvailabilityTimeOffset != 0 {
+			res.AvailabilityTimeOffset = t.AvailabilityTimeOffset
+		}
+		if t.SegmentTimeline != nil {
+			res.SegmentTimeline = t.SegmentTimeline
+		}
+	}
+	if res == nil {
+		return nil
+	}
+	if res.StartNumber == nil {
+		res.StartNumber = Int(1)
+	}
+	if res.Timescale == nil {
+		res.Timescale = Int(1)
+	}
+	return res
+}
+
+// Int returns a pointer to v, for setting optional attributes
+func Int(v int) *int {
+	return &v
+}
+
+type SegmentTimeline struct {
+	Segments []Segment `xml:"S"`
+}
+
+type Segment struct {
+	Time     uint64 `xml:"t,attr,omitempty"`
+	Duration int    `xml:"d,attr,omitempty"`
+	Repeat   int    `xml:"r,attr,omitempty"`
+}
+
+type Representation struct {
+	ID                string    `xml:"id,attr"`
+	AudioSamplingRate int       `xml:"audioSamplingRate,attr,omitempty"`
+	Bandwidth         int       `xml:"bandwidth,attr"`
+	Codecs            string    `xml:"codecs,attr,omitempty"`
+	MimeType          string    `xml:"mimeType,attr,omitempty"`
+	FrameRate         FrameRate `xml:"frameRate,attr,omitempty"`
+	Width             int       `xml:"width,attr,omitempty"`
+	Height            int       `xml:"height,attr,omitempty"`
+	SAR               string    `xml:"sar,attr,omitempty"`
+
+	AudioChannelConfiguration *AudioChannelConfiguration
+	ContentProtection         []ContentProtection
+	EssentialProperty         []Descriptor
+	SupplementalProperty      []Descriptor
+	BaseURL                   []BaseURL
+	SegmentBase               *SegmentBase
+	SegmentTemplate           *SegmentTemplate
+}
+
+// Descriptor is a scheme-defined property, used by EssentialProperty, SupplementalProperty, Role and Accessibility
+type Descriptor struct {
+	SchemeID string `xml:"schemeIdUri,attr"`
+	Value    string `xml:"value,attr,omitempty"`
+	ID       string `xml:"id,attr,omitempty"`
+}
+
+type AudioChannelConfiguration struct {
+	SchemeID string `xml:"schemeIdUri,attr"`
+	Value    int    `xml:"value,attr"`
+}
+
+type UTCTiming struct {
+	Scheme string `xml:"schemeIdUri,attr"`
+	Value  string `xml:"value,attr"`
+}
@@ -0,0 +1,157 @@
+package dashmpd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func marshalIndent(t *testing.T, m *MPD) []byte {
+	t.Helper()
+	blob, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte(xml.Header), blob...), '\n')
+}
+
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/*.mpd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range inputs {
+		t.Run(filepath.Base(input), func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m MPD
+			if err := xml.Unmarshal(src, &m); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Validate(); err != nil {
+				t.Error(err)
+			}
+			got := marshalIndent(t, &m)
+			golden := strings.TrimSuffix(input, ".mpd") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("output does not match %s:\n%s", golden, got)
+			}
+			// reading the output back must give the same model
+			var m2 MPD
+			if err := xml.Unmarshal(got, &m2); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m, m2) {
+				t.Errorf("round trip changed the MPD:\n%+v\n%+v", m, m2)
+			}
+		})
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	var m MPD
+	err := xml.Unmarshal([]byte(`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011"><Period><AdaptationSet><SegmentTemplate media="$Number$.m4s" duration="2"/></AdaptationSet></Period></MPD>`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := m.Period[0].AdaptationSet[0].SegmentTemplate
+	if m.AvailabilityStartTime != nil || m.Period[0].Start != nil || tmpl.StartNumber != nil || tmpl.Timescale != nil {
+		t.Error("absent attributes were set")
+	}
+	tmpl = ResolveTemplate(tmpl)
+	if *tmpl.StartNumber != 1 || *tmpl.Timescale != 1 {
+		t.Errorf("expected default startNumber and timescale of 1, got %d and %d", *tmpl.StartNumber, *tmpl.Timescale)
+	}
+}
+
+func TestInheritance(t *testing.T) {
+	src := `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="static" mediaPresentationDuration="PT10S">` +
+		`<Period><SegmentTemplate media="$RepresentationID$/$Number$.m4s" timescale="1000" duration="2000" startNumber="5"/>` +
+		`<AdaptationSet mimeType="video/mp4"><SegmentTemplate initialization="$RepresentationID$/init.mp4"/>` +
+		`<Representation id="v0" bandwidth="1000"/>` +
+		`<Representation id="v1" bandwidth="2000"><SegmentTemplate startNumber="0"/></Representation>` +
+		`</AdaptationSet></Period></MPD>`
+	var m MPD
+	if err := xml.Unmarshal([]byte(src), &m); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Error(err)
+	}
+	blob, err := xml.Marshal(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(blob), "startNumber") != 2 || strings.Count(string(blob), "timescale") != 1 {
+		t.Errorf("inherited attributes were written at other levels: %s", blob)
+	}
+	period, aset := m.Period[0], m.Period[0].AdaptationSet[0]
+	v0 := ResolveTemplate(period.SegmentTemplate, aset.SegmentTemplate, aset.Representation[0].SegmentTemplate)
+	v1 := ResolveTemplate(period.SegmentTemplate, aset.SegmentTemplate, aset.Representation[1].SegmentTemplate)
+	if v0.Media != "$RepresentationID$/$Number$.m4s" || v0.Initialization != "$RepresentationID$/init.mp4" || *v0.Timescale != 1000 || v0.Duration != 2000 {
+		t.Errorf("unexpected template %+v", v0)
+	}
+	if *v0.StartNumber != 5 || *v1.StartNumber != 0 {
+		t.Errorf("expected startNumber 5 and 0, got %d and %d", *v0.StartNumber, *v1.StartNumber)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ast := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MPD{
+		Profiles:              "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:                  "dynamic",
+		AvailabilityStartTime: &ast,
+		Period: []Period{{
+			AdaptationSet: []AdaptationSet{{
+				MimeType: "video/mp4",
+				SegmentTemplate: &SegmentTemplate{
+					Media:     "$Number$.m4s",
+					Timescale: Int(90000),
+					Duration:  180000,
+					SegmentTimeline: &SegmentTimeline{Segments: []Segment{
+						{Time: 1000, Duration: 10, Repeat: 1},
+						{Time: 1010, Duration: 10},
+						{Duration: 0},
+					}},
+				},
+				Representation: []Representation{{ID: "v0"}, {ID: "v0", Bandwidth: 1}},
+			}},
+		}},
+	}
+	err := m.Validate()
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	expected := []string{
+		"Period[1]: periods of a dynamic presentation need an id",
+		"Period[1]/AdaptationSet[1]/SegmentTemplate: has both a duration and a SegmentTimeline",
+		"Period[1]/AdaptationSet[1]/SegmentTemplate/SegmentTimeline/S[2]: overlaps the previous segment",
+		"Period[1]/AdaptationSet[1]/SegmentTemplate/SegmentTimeline/S[3]: duration must be positive",
+		"Period[1]/AdaptationSet[1]/Representation[@id='v0']: bandwidth is missing",
+		"Period[1]/AdaptationSet[1]/Representation[@id='v0']: duplicate id",
+	}
+	if !reflect.DeepEqual(verr.Problems, expected) {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(verr.Problems, "\n"))
+	}
+}
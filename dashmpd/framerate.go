@@ -0,0 +1,62 @@
+package dashmpd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FrameRate of a video stream in frames per second
+type FrameRate struct {
+	// Numerator of fractional rate
+	Numerator int
+	// Denominator of fractional rate. 1 if rate is integral, 0 if rate is floating-point
+	Denominator int
+	// Float value of rate
+	Float float64
+}
+
+// MarshalXMLAttr formats the frame rate as an integer, ratio or float
+func (r FrameRate) MarshalXMLAttr(name xml.Name) (attr xml.Attr, err error) {
+	if r.Numerator == 0 && r.Float == 0 {
+		return
+	}
+	attr.Name = name
+	switch r.Denominator {
+	case 0:
+		attr.Value = fmt.Sprintf("%.2f", r.Float)
+	case 1:
+		attr.Value = fmt.Sprintf("%d", r.Numerator)
+	default:
+		attr.Value = fmt.Sprintf("%d/%d", r.Numerator, r.Denominator)
+	}
+	return
+}
+
+// UnmarshalXMLAttr parses a frame rate in any of the forms produced by MarshalXMLAttr
+func (r *FrameRate) UnmarshalXMLAttr(attr xml.Attr) error {
+	num, den, isRatio := strings.Cut(attr.Value, "/")
+	if !isRatio {
+		if n, err := strconv.Atoi(num); err == nil {
+			*r = FrameRate{Numerator: n, Denominator: 1, Float: float64(n)}
+			return nil
+		}
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return fmt.Errorf("invalid frame rate %q", attr.Value)
+		}
+		*r = FrameRate{Float: f}
+		return nil
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return fmt.Errorf("invalid frame rate %q", attr.Value)
+	}
+	d, err := strconv.Atoi(den)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid frame rate %q", attr.Value)
+	}
+	*r = FrameRate{Numerator: n, Denominator: d, Float: float64(n) / float64(d)}
+	return nil
+}
@@ -0,0 +1,99 @@
+package dashmpd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is an ISO 8601 duration such as PT1M30S
+type Duration struct {
+	time.Duration
+}
+
+// MarshalText formats the duration in hours, minutes and seconds
+func (d Duration) MarshalText() ([]byte, error) {
+	ret := []byte("PT")
+	dur := d.Duration
+	if dur >= time.Hour {
+		v := dur / time.Hour
+		ret = strconv.AppendInt(ret, int64(v), 10)
+		ret = append(ret, 'H')
+		dur -= v * time.Hour
+	}
+	if dur >= time.Minute {
+		v := dur / time.Minute
+		ret = strconv.AppendInt(ret, int64(v), 10)
+		ret = append(ret, 'M')
+		dur -= v * time.Minute
+	}
+	if dur != 0 || len(ret) == 2 {
+		sec := dur.Seconds()
+		if float64(int(sec)) == sec {
+			ret = strconv.AppendInt(ret, int64(sec), 10)
+			ret = append(ret, 'S')
+		} else {
+			ret = strconv.AppendFloat(ret, sec, 'f', -1, 64)
+			ret = append(ret, 'S')
+		}
+	}
+	return ret, nil
+}
+
+// UnmarshalText parses an ISO 8601 duration. Years and months have no fixed
+// length, so only days and smaller units are accepted.
+func (d *Duration) UnmarshalText(text []byte) error {
+	s := string(text)
+	invalid := fmt.Errorf("invalid duration %q", s)
+	var neg bool
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 2 {
+		return invalid
+	}
+	s = s[1:]
+	var dur time.Duration
+	var inTime bool
+	for s != "" {
+		if s[0] == 'T' {
+			if inTime || len(s) == 1 {
+				return invalid
+			}
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexAny(s, "YMWDHS")
+		if i <= 0 {
+			return invalid
+		}
+		v, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil || v < 0 {
+			return invalid
+		}
+		var unit time.Duration
+		switch {
+		case s[i] == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case s[i] == 'H' && inTime:
+			unit = time.Hour
+		case s[i] == 'M' && inTime:
+			unit = time.Minute
+		case s[i] == 'S' && inTime:
+			unit = time.Second
+		default:
+			return invalid
+		}
+		dur += time.Duration(math.Round(v * float64(unit)))
+		s = s[i+1:]
+	}
+	if neg {
+		dur = -dur
+	}
+	d.Duration = dur
+	return nil
+}
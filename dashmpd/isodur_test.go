@@ -0,0 +1,42 @@
+package dashmpd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	values := []struct {
+		V   string
+		D   time.Duration
+		Out string
+	}{
+		{"PT0S", 0, "PT0S"},
+		{"PT2S", 2 * time.Second, "PT2S"},
+		{"PT1.5S", 1500 * time.Millisecond, "PT1.5S"},
+		{"PT1H2M3.5S", time.Hour + 2*time.Minute + 3500*time.Millisecond, "PT1H2M3.5S"},
+		{"PT90M", 90 * time.Minute, "PT1H30M"},
+		{"P1DT1S", 24*time.Hour + time.Second, "PT24H1S"},
+		{"P2D", 48 * time.Hour, "PT48H"},
+	}
+	for _, ex := range values {
+		var d Duration
+		if err := d.UnmarshalText([]byte(ex.V)); err != nil {
+			t.Errorf("%s: %s", ex.V, err)
+			continue
+		}
+		if d.Duration != ex.D {
+			t.Errorf("%s: expected %s, got %s", ex.V, ex.D, d.Duration)
+		}
+		out, _ := d.MarshalText()
+		if string(out) != ex.Out {
+			t.Errorf("%s: expected to format as %s, got %s", ex.V, ex.Out, out)
+		}
+	}
+	for _, v := range []string{"", "P", "PT", "1S", "P1Y", "P1M", "PT1D", "P1S", "PTS", "PT-1S", "PT1S2"} {
+		var d Duration
+		if err := d.UnmarshalText([]byte(v)); err == nil {
+			t.Errorf("%q: expected an error, got %s", v, d.Duration)
+		}
+	}
+}
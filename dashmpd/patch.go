@@ -0,0 +1,104 @@
+package dashmpd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// PatchNamespace is the XML namespace of MPD patch documents
+const PatchNamespace = "urn:mpeg:dash:schema:mpd-patch:2020"
+
+// Patch is an MPD patch document, which brings a client's copy of an MPD up
+// to date. Namespace should be set to PatchNamespace.
+type Patch struct {
+	XMLName             xml.Name  `xml:"Patch"`
+	Namespace           string    `xml:"xmlns,attr"`
+	MPDID               string    `xml:"mpdId,attr"`
+	OriginalPublishTime time.Time `xml:"originalPublishTime,attr"`
+	PublishTime         time.Time `xml:"publishTime,attr"`
+
+	Operations []PatchOperation
+}
+
+// PatchOperation adds, replaces or removes the node selected by an XPath expression
+type PatchOperation struct {
+	XMLName xml.Name
+	Sel     string `xml:"sel,attr"`
+	Pos     string `xml:"pos,attr,omitempty"`
+	Content []byte `xml:",innerxml"`
+}
+
+// PatchLocation tells the client where to fetch a patch to the current MPD, and for how long it can be used
+type PatchLocation struct {
+	TTL int    `xml:"ttl,attr,omitempty"`
+	URL string `xml:",chardata"`
+}
+
+// Add appends elements to the selected node, or inserts them next to it if pos is "before" or "after"
+func (p *Patch) Add(sel, pos string, elements ...Element) error {
+	content, err := marshalElements(elements)
+	if err != nil {
+		return err
+	}
+	p.Operations = append(p.Operations, PatchOperation{
+		XMLName: xml.Name{Local: "add"},
+		Sel:     sel,
+		Pos:     pos,
+		Content: content,
+	})
+	return nil
+}
+
+// Replace the selected element
+func (p *Patch) Replace(sel string, element Element) error {
+	content, err := marshalElements([]Element{element})
+	if err != nil {
+		return err
+	}
+	p.Operations = append(p.Operations, PatchOperation{
+		XMLName: xml.Name{Local: "replace"},
+		Sel:     sel,
+		Content: content,
+	})
+	return nil
+}
+
+// ReplaceValue replaces the selected attribute with a new value
+func (p *Patch) ReplaceValue(sel, value string) {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(value))
+	p.Operations = append(p.Operations, PatchOperation{
+		XMLName: xml.Name{Local: "replace"},
+		Sel:     sel,
+		Content: b.Bytes(),
+	})
+}
+
+// Remove the selected node
+func (p *Patch) Remove(sel string) {
+	p.Operations = append(p.Operations, PatchOperation{
+		XMLName: xml.Name{Local: "remove"},
+		Sel:     sel,
+	})
+}
+
+// Element is an MPD element to be inserted by a patch
+type Element struct {
+	Name  string
+	Value interface{}
+}
+
+func marshalElements(elements []Element) ([]byte, error) {
+	var b bytes.Buffer
+	e := xml.NewEncoder(&b)
+	for _, el := range elements {
+		if err := e.EncodeElement(el.Value, xml.StartElement{Name: xml.Name{Local: el.Name}}); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,39 @@
+package dashmpd
+
+import "encoding/xml"
+
+// CENCNamespace is the XML namespace of the Common Encryption extensions to the MPD
+const CENCNamespace = "urn:mpeg:cenc:2013"
+
+// ContentProtection signals a DRM or encryption scheme that the content is protected with
+type ContentProtection struct {
+	SchemeID string `xml:"schemeIdUri,attr"`
+	Value    string `xml:"value,attr,omitempty"`
+	// DefaultKID is the cenc:default_KID attribute, the key ID in UUID form
+	DefaultKID string `xml:"urn:mpeg:cenc:2013 default_KID,attr,omitempty"`
+	// PSSH holds the base64 content of cenc:pssh elements
+	PSSH []string `xml:"urn:mpeg:cenc:2013 pssh"`
+}
+
+// MarshalXML writes the Common Encryption attributes with the conventional cenc prefix
+func (c ContentProtection) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "schemeIdUri"}, Value: c.SchemeID})
+	if c.Value != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "value"}, Value: c.Value})
+	}
+	if c.DefaultKID != "" || len(c.PSSH) != 0 {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns:cenc"}, Value: CENCNamespace})
+	}
+	if c.DefaultKID != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "cenc:default_KID"}, Value: c.DefaultKID})
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, pssh := range c.PSSH {
+		if err := e.EncodeElement(pssh, xml.StartElement{Name: xml.Name{Local: "cenc:pssh"}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,197 @@
+package dashmpd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError lists the problems found by Validate
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid MPD: " + strings.Join(e.Problems, "; ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the MPD for missing or inconsistent values that would keep
+// a player from using it. It returns a *ValidationError describing each
+// problem found, or nil.
+func (m *MPD) Validate() error {
+	v := new(validator)
+	if m.Profiles == "" {
+		v.addf("MPD: profiles is missing")
+	}
+	switch m.Type {
+	case "", "static":
+		if m.MediaPresentationDuration == nil && (len(m.Period) == 0 || m.Period[len(m.Period)-1].Duration == nil) {
+			v.addf("MPD: static presentation has no mediaPresentationDuration")
+		}
+	case "dynamic":
+		if m.AvailabilityStartTime == nil {
+			v.addf("MPD: dynamic presentation has no availabilityStartTime")
+		}
+	default:
+		v.addf("MPD: unknown type %q", m.Type)
+	}
+	if len(m.Period) == 0 {
+		v.addf("MPD: no periods")
+	}
+	periodIDs := make(map[string]bool)
+	var lastStart *Duration
+	for i, period := range m.Period {
+		where := fmt.Sprintf("Period[%d]", i+1)
+		if period.ID != "" {
+			where = fmt.Sprintf("Period[@id='%s']", period.ID)
+			if periodIDs[period.ID] {
+				v.addf("%s: duplicate id", where)
+			}
+			periodIDs[period.ID] = true
+		} else if m.Type == "dynamic" {
+			v.addf("%s: periods of a dynamic presentation need an id", where)
+		}
+		if period.Start != nil {
+			if lastStart != nil && period.Start.Duration < lastStart.Duration {
+				v.addf("%s: starts before the previous period", where)
+			}
+			lastStart = period.Start
+		}
+		v.period(where, &period)
+	}
+	if len(v.problems) != 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+func (v *validator) period(where string, period *Period) {
+	for i, es := range period.EventStream {
+		if es.SchemeID == "" {
+			v.addf("%s/EventStream[%d]: schemeIdUri is missing", where, i+1)
+		}
+	}
+	if len(period.AdaptationSet) == 0 {
+		v.addf("%s: no adaptation sets", where)
+	}
+	// templates are checked at the innermost level that has one, once merged
+	// with the levels above, and outer ones only if something relies on them
+	if period.SegmentTemplate != nil {
+		for _, aset := range period.AdaptationSet {
+			if aset.SegmentTemplate == nil && inheritsTemplate(&aset) {
+				v.template(where, ResolveTemplate(period.SegmentTemplate))
+				break
+			}
+		}
+	}
+	repIDs := make(map[string]bool)
+	for i, aset := range period.AdaptationSet {
+		asetWhere := fmt.Sprintf("%s/AdaptationSet[%d]", where, i+1)
+		if aset.ID != "" {
+			asetWhere = fmt.Sprintf("%s/AdaptationSet[@id='%s']", where, aset.ID)
+		}
+		v.protection(asetWhere, aset.ContentProtection)
+		if aset.SegmentTemplate != nil && inheritsTemplate(&aset) {
+			v.template(asetWhere, ResolveTemplate(period.SegmentTemplate, aset.SegmentTemplate))
+		}
+		if len(aset.Representation) == 0 {
+			v.addf("%s: no representations", asetWhere)
+		}
+		for j, rep := range aset.Representation {
+			repWhere := fmt.Sprintf("%s/Representation[%d]", asetWhere, j+1)
+			if rep.ID == "" {
+				v.addf("%s: id is missing", repWhere)
+			} else {
+				repWhere = fmt.Sprintf("%s/Representation[@id='%s']", asetWhere, rep.ID)
+				if repIDs[rep.ID] {
+					v.addf("%s: duplicate id", repWhere)
+				}
+				repIDs[rep.ID] = true
+			}
+			if rep.Bandwidth <= 0 {
+				v.addf("%s: bandwidth is missing", repWhere)
+			}
+			if aset.MimeType == "" && rep.MimeType == "" {
+				v.addf("%s: mimeType is missing", repWhere)
+			}
+			v.protection(repWhere, rep.ContentProtection)
+			if rep.SegmentTemplate != nil {
+				v.template(repWhere, ResolveTemplate(period.SegmentTemplate, aset.SegmentTemplate, rep.SegmentTemplate))
+			}
+			addressed := rep.SegmentTemplate != nil || rep.SegmentBase != nil || len(rep.BaseURL) != 0 ||
+				aset.SegmentTemplate != nil || aset.SegmentBase != nil ||
+				period.SegmentTemplate != nil || period.SegmentBase != nil
+			if !addressed {
+				v.addf("%s: no SegmentTemplate, SegmentBase or BaseURL locates its segments", repWhere)
+			}
+		}
+	}
+}
+
+// reports whether any representation of the adaptation set relies on a template from a level above it
+func inheritsTemplate(aset *AdaptationSet) bool {
+	if aset.SegmentBase != nil {
+		return false
+	}
+	for _, rep := range aset.Representation {
+		if rep.SegmentTemplate == nil && rep.SegmentBase == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *validator) protection(where string, cps []ContentProtection) {
+	for i, cp := range cps {
+		if cp.SchemeID == "" {
+			v.addf("%s/ContentProtection[%d]: schemeIdUri is missing", where, i+1)
+		}
+	}
+}
+
+// check a template that has been merged with those it inherits from
+func (v *validator) template(where string, tmpl *SegmentTemplate) {
+	where += "/SegmentTemplate"
+	if tmpl.Media == "" {
+		v.addf("%s: media is missing", where)
+	}
+	if *tmpl.Timescale <= 0 {
+		v.addf("%s: timescale must be positive", where)
+	}
+	tl := tmpl.SegmentTimeline
+	if tl == nil {
+		if tmpl.Duration <= 0 {
+			v.addf("%s: needs a duration or SegmentTimeline", where)
+		}
+		return
+	} else if tmpl.Duration > 0 {
+		v.addf("%s: has both a duration and a SegmentTimeline", where)
+	}
+	if len(tl.Segments) == 0 {
+		v.addf("%s/SegmentTimeline: no segments", where)
+	}
+	var next uint64
+	for i, s := range tl.Segments {
+		if s.Duration <= 0 {
+			v.addf("%s/SegmentTimeline/S[%d]: duration must be positive", where, i+1)
+			return
+		}
+		if s.Repeat < -1 || (s.Repeat == -1 && i != len(tl.Segments)-1) {
+			v.addf("%s/SegmentTimeline/S[%d]: invalid repeat count %d", where, i+1, s.Repeat)
+			return
+		}
+		if i != 0 && s.Time != 0 && s.Time < next {
+			v.addf("%s/SegmentTimeline/S[%d]: overlaps the previous segment", where, i+1)
+		} else if s.Time != 0 || i == 0 {
+			next = s.Time
+		}
+		next += uint64(s.Duration) * uint64(s.Repeat+1)
+	}
+}
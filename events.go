@@ -0,0 +1,107 @@
+package hls
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"eaglesong.dev/hls/internal/naming"
+	"eaglesong.dev/hls/internal/segment"
+)
+
+// Observer is notified as the stream changes. Methods are called with the
+// publisher locked, so they must not block or call back into the publisher.
+// Embed NopObserver to implement only some of them.
+type Observer interface {
+	// SegmentStarted is called when a new segment begins, once per track
+	SegmentStarted(SegmentEvent)
+	// PartAppended is called when a part is added to the segment in progress
+	PartAppended(PartEvent)
+	// SegmentFinalized is called when a segment is complete. Its Contents can be read until it is released.
+	SegmentFinalized(SegmentEvent)
+	// SegmentReleased is called when a segment is removed from the playlist
+	SegmentReleased(SegmentEvent)
+	// Discontinuity is called when a segment begins following a break in the stream
+	Discontinuity(msn int)
+	// Closed is called when the publisher is closed
+	Closed()
+}
+
+// SegmentEvent describes a segment of one track
+type SegmentEvent struct {
+	Track int
+	MSN   int
+	// Name is the filename of the segment relative to the publisher
+	Name          string
+	Start         time.Duration
+	Duration      time.Duration // only when finalized
+	Size          int64
+	Parts         int
+	Independent   bool // begins with a keyframe
+	Discontinuous bool
+	// Contents of a finalized segment
+	Contents *io.SectionReader
+}
+
+// PartEvent describes a part of a segment in progress
+type PartEvent struct {
+	Track int
+	MSN   int
+	Part  int
+	// Name is the filename of the part relative to the publisher
+	Name        string
+	Duration    time.Duration
+	Independent bool
+	// Bytes of the part, which must not be modified
+	Bytes []byte
+}
+
+// NopObserver implements Observer by doing nothing
+type NopObserver struct{}
+
+func (NopObserver) SegmentStarted(SegmentEvent)   {}
+func (NopObserver) PartAppended(PartEvent)        {}
+func (NopObserver) SegmentFinalized(SegmentEvent) {}
+func (NopObserver) SegmentReleased(SegmentEvent)  {}
+func (NopObserver) Discontinuity(msn int)         {}
+func (NopObserver) Closed()                       {}
+
+// describe a segment for observers
+func (p *Publisher) segmentEvent(trackID int, msn segment.MSN, seg *segment.Segment) SegmentEvent {
+	return SegmentEvent{
+		Track:         trackID,
+		MSN:           int(msn),
+		Name:          p.segmentName(trackID, msn, -1),
+		Start:         seg.Start(),
+		Duration:      seg.Duration(),
+		Size:          seg.Size(),
+		Parts:         seg.Parts(),
+		Independent:   seg.Independent(),
+		Discontinuous: seg.Discontinuous(),
+	}
+}
+
+// get the name of a segment or part relative to the publisher
+func (p *Publisher) segmentName(trackID int, msn segment.MSN, part int) string {
+	return p.names.Format(naming.Name{
+		Track: trackID,
+		MSN:   strconv.FormatInt(int64(msn), 10),
+		Part:  part,
+		Ext:   p.tracks[trackID].hdr.SegmentExtension,
+	})
+}
+
+// complete the segments in progress on all tracks
+func (p *Publisher) finalizeSegments(next time.Duration) {
+	msn := p.baseMSN + segment.MSN(len(p.primary.segments)-1)
+	for trackID, track := range p.tracks {
+		seg := track.current()
+		seg.Finalize(next)
+		if p.Observer != nil {
+			ev := p.segmentEvent(trackID, msn, seg)
+			ev.Contents = seg.Contents()
+			p.Observer.SegmentFinalized(ev)
+		}
+	}
+	p.recordSegment(p.primary.current())
+}
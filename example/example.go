@@ -13,12 +13,15 @@ import (
 )
 
 func main() {
-	pub := &hls.Publisher{}
+	srv := &hls.Server{}
 	rts := &rtmp.Server{
 		HandlePublish: func(c *rtmp.Conn) {
 			defer c.Close()
-			log.Println("publish started from", c.NetConn().RemoteAddr())
-			if err := avutil.CopyFile(pub, c); err != nil {
+			name := strings.Trim(c.URL.Path, "/")
+			log.Printf("publish of %q started from %s", name, c.NetConn().RemoteAddr())
+			src := srv.Publish(name)
+			defer src.Close()
+			if err := avutil.CopyFile(src, c); err != nil {
 				log.Printf("error: publishing from %s: %+v", c.NetConn().RemoteAddr(), err)
 			}
 		},
@@ -26,7 +29,7 @@ func main() {
 	var eg errgroup.Group
 	eg.Go(rts.ListenAndServe)
 
-	http.Handle("/hls/", pub)
+	http.Handle("/hls/", http.StripPrefix("/hls", srv))
 	http.Handle("/", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		r := strings.NewReader(home)
 		http.ServeContent(rw, req, "index.html", time.Time{}, r)
@@ -51,7 +54,7 @@ const home = `<!DOCTYPE html>
 <video id="video" muted autoplay controls></video>
 <script>
 let hls = new Hls();
-hls.loadSource('/hls/index.m3u8');
+hls.loadSource('/hls/live/index.m3u8');
 hls.attachMedia(document.getElementById('video'));
 // hls.on(Hls.Events.MANIFEST_PARSED, () => video.play());
 </script>
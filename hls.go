@@ -1,20 +1,24 @@
 package hls
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"eaglesong.dev/hls/dashmpd"
 	"eaglesong.dev/hls/internal/codectag"
-	"eaglesong.dev/hls/internal/dashmpd"
 	"eaglesong.dev/hls/internal/fmp4"
 	"eaglesong.dev/hls/internal/fragment"
+	"eaglesong.dev/hls/internal/naming"
 	"eaglesong.dev/hls/internal/ratedetect"
 	"eaglesong.dev/hls/internal/segment"
 	"eaglesong.dev/hls/internal/tsfrag"
+	"eaglesong.dev/hls/internal/tsnorm"
 	"github.com/nareix/joy4/av"
 )
 
@@ -52,20 +56,67 @@ type Publisher struct {
 	FragmentLength time.Duration
 	// WorkDir is a temporary storage location for segments. Can be empty, in which case the default system temp dir is used.
 	WorkDir string
+	// URLTemplate lays out the filenames of each track's playlist and segments, which may include directories. It is made up of the placeholders {track}, {pid}, {msn}, {part} and {ext}, for example "{pid}/{track}/{msn}{part}{ext}". {msn} is "init" for initialization segments and empty for playlists, and {part} is empty for whole segments. {track} and {msn} must be separated by something other than a digit, which {pid} always contains. Defaults to "{track}{pid}{msn}{part}{ext}".
+	URLTemplate string
+	// Name identifies the stream to the Authorizer. It is set by Server.
+	Name string
+	// Authorizer, if set, is consulted before serving any playlist or segment. Query parameters it returns are propagated into the URIs of the playlists.
+	Authorizer Authorizer
+	// Thumbnails, if set, publishes an image track of thumbnail tiles for scrubbing previews. In HLS it is only advertised when there is a master playlist, i.e. in ModeSeparateTracks.
+	Thumbnails *ThumbnailConfig
+	// Observer, if set, is notified of segments and other changes to the stream
+	Observer Observer
+	// Sessions, if set, tracks the viewers of the stream. It may be shared between publishers.
+	Sessions *Sessions
 	// Prefetch reveals upcoming segments before they begin so the client can initiate the download early
 	Prefetch bool
 	// BlockMPD causes conditional DASH playlist fetches to block until an updated version is ready
 	BlockMPD bool
+	// DASHTimeAddressing names DASH segments by their presentation time ($Time$) instead of their number, so that URLs are stable when numbering changes
+	DASHTimeAddressing bool
+	// PatchMPD advertises a PatchLocation in the DASH MPD so that clients can fetch just the segments added since their last refresh instead of the whole timeline
+	PatchMPD bool
+	// DASHLatency, if set, advertises a target latency to low-latency DASH players
+	DASHLatency *LatencyTarget
+	// AlignSegments begins a new segment at the first keyframe after each multiple of this duration in wall-clock time, as given by ExtendedPacket.ProgramTime, instead of at every keyframe. Publishers with synchronized clocks will then have matching segment boundaries. It should be a multiple of the source's keyframe interval.
+	AlignSegments time.Duration
+	// Epoch, if set, fixes the numbering of segments so that a restarted publisher continues where the previous one left off. Each segment is numbered by the count of AlignSegments intervals between the epoch and its program time, so independent publishers agree on the number of a segment beginning at a given time. Intervals in which no segment began are listed as gaps in HLS and start a new DASH period. The DASH availabilityStartTime is the epoch itself. AlignSegments must be set. Packets are discarded until a keyframe with a ProgramTime arrives, and a ProgramTime before the epoch is an error. Initialization segments are named after a hash of their contents so that a restarted publisher with different codec parameters doesn't reuse a cached one.
+	Epoch time.Time
+	// MaxSegmentDuration forces a new segment to begin if the source hasn't sent a keyframe in this long. The new segment starts with a non-keyframe, so players can't begin playback from it. Disabled by default.
+	MaxSegmentDuration time.Duration
+	// OnWarning is called when the source is misbehaving in a way that degrades the stream, such as sending keyframes too far apart. It is called with the publisher locked and must not block.
+	OnWarning func(msg string)
+	// MaxTimestampJump is the largest gap between consecutive packets of a stream that is considered continuous. A larger gap, or a step backwards, causes a discontinuity to be inserted. Small steps backwards are tolerated if NormalizeTimestamps is set. Defaults to 10s. A negative value disables detection.
+	MaxTimestampJump time.Duration
+	// RebaseTimestamps shifts timestamps following a jump so that they continue from where the stream left off, instead of starting a new timeline.
+	RebaseTimestamps bool
+	// NormalizeTimestamps starts every track at zero, keeps decode times increasing within each track, and corrects gradual drift between audio and video.
+	NormalizeTimestamps bool
+	// MaxDrift is how far audio may drift from video before its sample durations are adjusted to bring it back in line. Only used with NormalizeTimestamps. Defaults to 40ms. A negative value disables correction.
+	MaxDrift time.Duration
 
-	mu      sync.Mutex
-	pid     string // unique filename for this instance of the stream
-	streams []av.CodecData
-	tracks  []*track
-	combo   *track
-	primary *track      // combo track or video track if no combo
-	comboID int         // index of combo track, for naming its segment files
-	vidx    int         // index of video in incoming stream
-	baseMSN segment.MSN // MSN of segments[0][0]
+	mu        sync.Mutex
+	pid       string // unique filename for this instance of the stream
+	names     *naming.Template
+	streams   []av.CodecData
+	tracks    []*track
+	combo     *track
+	primary   *track        // combo track or video track if no combo
+	comboID   int           // index of combo track, for naming its segment files
+	vidx      int           // index of video in incoming stream
+	baseMSN   segment.MSN   // MSN of segments[0][0]
+	lastVideo time.Duration // timestamp of the most recent video packet
+	keyframe  av.Packet     // most recent video keyframe, for snapshots
+	thumbs    *thumbState
+	// initialization segments for playing all separate tracks as one stream
+	muxHeaders []trackHeader
+	muxGen     int
+	ts         []tsState
+	norm       *tsnorm.Normalizer
+	alignSlot  int64     // interval in which the current segment began
+	wallBase   time.Time // most recent program time, for estimating the wall-clock time of other packets
+	wallTime   time.Duration
+	firstWall  time.Duration // time between the epoch and the first segment
 
 	// hls
 	baseDCN int  // number of previous discontinuities
@@ -73,13 +124,19 @@ type Publisher struct {
 	state   atomic.Value
 
 	// dash
-	rate ratedetect.Detector
-	mpd  dashmpd.MPD
-	prev hlsState
+	rate       ratedetect.Detector
+	mpd        dashmpd.MPD
+	periods    []*dashPeriod
+	mpdHistory []dashmpd.MPD // recent versions that can be patched
+	nextPeriod bool          // if next segment starts a new period
+	prev       hlsState
 
 	subsMu sync.Mutex
 	subs   subMap
 
+	metricsOnce sync.Once
+	metrics     *publisherMetrics
+
 	// Precreate is deprecated and no longer used
 	Precreate int
 }
@@ -89,45 +146,116 @@ type track struct {
 	frag     fragment.Fragmenter
 	hdr      fragment.Header
 	codecTag string
+	headers  []trackHeader // initialization segments still referenced by the playlist
+	hdrGen   int
+}
+
+// an initialization segment and the first segment that uses it
+type trackHeader struct {
+	fragment.Header
+	name string
+	msn  segment.MSN
 }
 
-// WriteHeader initializes the streams' codec data and must be called before the first WritePacket
+// WriteHeader initializes the streams' codec data and must be called before the first WritePacket.
+//
+// It may be called again on a live publisher when the source reconnects or its
+// codec parameters change, as long as the number and type of streams is the
+// same. The segment in progress is completed and the next one is marked as a
+// discontinuity, and a new initialization segment is published if needed.
 func (p *Publisher) WriteHeader(streams []av.CodecData) error {
-	if len(streams) > 9 {
-		return errors.New("too many streams")
-	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.pid = strconv.FormatInt(time.Now().Unix(), 36)
+	if len(p.tracks) != 0 {
+		return p.reconfigure(streams)
+	}
+	if p.Epoch.IsZero() {
+		p.pid = instanceID(time.Now())
+	} else if p.AlignSegments <= 0 {
+		// segment durations vary, so only aligned segments can be numbered by time
+		return errors.New("Epoch requires AlignSegments to be set")
+	} else {
+		// stable across restarts
+		p.pid = instanceID(p.Epoch)
+	}
+	if p.Thumbnails != nil && p.Thumbnails.Encoder == nil {
+		return errors.New("Thumbnails requires an Encoder")
+	}
+	names, err := naming.Compile(p.URLTemplate, p.pid)
+	if err != nil {
+		return err
+	}
+	p.names = names
 	p.streams = streams
+	p.ts = make([]tsState, len(streams))
+	if p.NormalizeTimestamps {
+		maxDrift := p.MaxDrift
+		if maxDrift == 0 {
+			maxDrift = defaultMaxDrift
+		}
+		p.norm = tsnorm.New(streams, maxDrift)
+	}
 	p.comboID = -1
 	for i, cd := range streams {
 		if cd.Type().IsVideo() {
 			p.vidx = i
 		}
 	}
+	tracks, err := p.makeTracks(streams)
+	if err != nil {
+		return err
+	}
+	p.tracks = tracks
+	p.initThumbnails()
+	for trackID, t := range tracks {
+		t.addHeader(p.names, trackID, 0, !p.Epoch.IsZero())
+	}
+	if err := p.addMuxHeader(0); err != nil {
+		return err
+	}
+	if p.Mode != ModeSingleTrack {
+		p.primary = p.tracks[p.vidx]
+		p.initMPD()
+	}
+	if p.Mode != ModeSeparateTracks {
+		p.comboID = len(p.tracks) - 1
+		p.combo = p.tracks[p.comboID]
+		p.primary = p.combo
+	}
+	return nil
+}
+
+// name an instance of the stream after a time. it always contains a letter so
+// that it can separate the track and segment numbers in a URL.
+func instanceID(t time.Time) string {
+	pid := strconv.FormatInt(t.Unix(), 36)
+	if strings.Trim(pid, "0123456789") == "" {
+		pid = "p" + pid
+	}
+	return pid
+}
+
+// create fragmenters for each output track
+func (p *Publisher) makeTracks(streams []av.CodecData) ([]*track, error) {
+	var tracks []*track
 	if p.Mode != ModeSingleTrack {
 		// setup separate tracks
+		frags, err := fmp4.NewTracks(streams)
+		if err != nil {
+			return nil, err
+		}
 		for i, cd := range streams {
-			frag, err := fmp4.NewTrack(cd)
-			if err != nil {
-				return fmt.Errorf("stream %d: %w", i, err)
-			}
+			frag := frags[i]
 			tag, err := codectag.Tag(cd)
 			if err != nil {
-				return fmt.Errorf("stream %d: %w", i, err)
+				return nil, fmt.Errorf("stream %d: %w", i, err)
 			}
-			t := &track{
+			tracks = append(tracks, &track{
 				frag:     frag,
 				hdr:      frag.Header(),
 				codecTag: tag,
-			}
-			p.tracks = append(p.tracks, t)
-			if i == p.vidx {
-				p.primary = t
-			}
+			})
 		}
-		p.initMPD()
 	}
 	if p.Mode != ModeSeparateTracks {
 		// setup combined track
@@ -139,17 +267,83 @@ func (p *Publisher) WriteHeader(streams []av.CodecData) error {
 			cfrag, err = fmp4.NewMovie(streams)
 		}
 		if err != nil {
-			return fmt.Errorf("combined: %w", err)
+			return nil, fmt.Errorf("combined: %w", err)
+		}
+		tracks = append(tracks, &track{frag: cfrag, hdr: cfrag.Header()})
+	}
+	return tracks, nil
+}
+
+// switch to new codec parameters without interrupting the stream
+func (p *Publisher) reconfigure(streams []av.CodecData) error {
+	if !sameLayout(p.streams, streams) {
+		return errors.New("stream layout changed")
+	}
+	if err := p.restartTracks(streams); err != nil {
+		return err
+	}
+	p.snapshot(0)
+	return nil
+}
+
+// complete the segment in progress and replace all fragmenters. the next
+// segment will begin at a keyframe and be marked as a discontinuity.
+func (p *Publisher) restartTracks(streams []av.CodecData) error {
+	tracks, err := p.makeTracks(streams)
+	if err != nil {
+		return err
+	}
+	if p.thumbs != nil {
+		// finish the tile with the old codec
+		p.submitThumbnails()
+	}
+	if p.primary.live() {
+		// the last frame is still pending in the old fragmenter and is discarded
+		if err := p.flush(); err != nil {
+			return err
 		}
-		t := &track{frag: cfrag, hdr: cfrag.Header()}
-		p.comboID = len(p.tracks)
-		p.tracks = append(p.tracks, t)
-		p.combo = t
-		p.primary = t
+		p.finalizeSegments(p.lastVideo)
+	}
+	nextMSN := p.baseMSN + segment.MSN(len(p.primary.segments))
+	for trackID, t := range tracks {
+		old := p.tracks[trackID]
+		old.frag = t.frag
+		old.codecTag = t.codecTag
+		if !bytes.Equal(old.hdr.HeaderContents, t.hdr.HeaderContents) {
+			old.hdr = t.hdr
+			old.addHeader(p.names, trackID, nextMSN, !p.Epoch.IsZero())
+		}
+	}
+	p.streams = streams
+	if err := p.addMuxHeader(nextMSN); err != nil {
+		return err
+	}
+	p.nextDCN = true
+	p.nextPeriod = p.Mode != ModeSingleTrack
+	p.rate = ratedetect.Detector{}
+	p.wallBase = time.Time{}
+	// can't be decoded with the new codec parameters
+	p.keyframe = av.Packet{}
+	if p.norm != nil {
+		// new fragmenters have no correction, so start measuring over
+		p.norm.Reset()
 	}
 	return nil
 }
 
+// check if two sets of streams have the same number and type of tracks
+func sameLayout(a, b []av.CodecData) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type() != b[i].Type() {
+			return false
+		}
+	}
+	return true
+}
+
 // WriteTrailer does nothing, but fulfills av.Muxer
 func (p *Publisher) WriteTrailer() error {
 	return nil
@@ -171,15 +365,29 @@ func (p *Publisher) WritePacket(pkt av.Packet) error {
 func (p *Publisher) WriteExtendedPacket(pkt ExtendedPacket) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	// enqueue packet to fragmenter
+	if !p.Epoch.IsZero() {
+		if ok, err := p.checkEpoch(pkt); !ok {
+			return err
+		}
+	}
+	if err := p.checkJump(&pkt); err != nil {
+		return err
+	}
+	if p.norm != nil {
+		p.normalize(&pkt.Packet)
+	}
+	p.recordPacket(pkt)
+	// enqueue packet to fragmenter. a video keyframe is always accepted because
+	// it will begin a new segment if one isn't in progress.
+	startsSegment := pkt.IsKeyFrame && int(pkt.Idx) == p.vidx
 	if p.Mode != ModeSingleTrack {
-		if t := p.tracks[pkt.Idx]; len(t.segments) != 0 {
+		if t := p.tracks[pkt.Idx]; t.live() || startsSegment {
 			if err := t.frag.WritePacket(pkt.Packet); err != nil {
 				return err
 			}
 		}
 	}
-	if p.Mode != ModeSeparateTracks && len(p.combo.segments) != 0 {
+	if p.Mode != ModeSeparateTracks && (p.combo.live() || startsSegment) {
 		if err := p.combo.frag.WritePacket(pkt.Packet); err != nil {
 			return err
 		}
@@ -188,16 +396,26 @@ func (p *Publisher) WriteExtendedPacket(pkt ExtendedPacket) error {
 		return nil
 	}
 	p.rate.Append(pkt.Packet.Time)
+	p.lastVideo = pkt.Time
+	if pkt.IsKeyFrame {
+		p.keyframe = pkt.Packet
+	}
 	fragLen := p.FragmentLength
 	if fragLen <= 0 {
 		fragLen = defaultFragmentLength
 	}
-	if pkt.IsKeyFrame {
+	if pkt.IsKeyFrame && p.segmentBoundary(pkt) {
 		// the fragmenter retains the last packet in order to calculate the
 		// duration of the previous frame. so switching segments here will put
 		// this keyframe into the new segment.
 		return p.newSegment(pkt.Time, pkt.ProgramTime)
-	} else if len(p.primary.segments) != 0 && p.primary.frag.Duration() >= fragLen-slopOffset {
+	} else if cur := p.primary.current(); p.MaxSegmentDuration > 0 && p.primary.live() && pkt.Time-cur.Start() >= p.MaxSegmentDuration && (p.Epoch.IsZero() || p.segmentBoundary(pkt)) {
+		// keyframes are too far apart, so split the segment here. parts
+		// starting with this frame won't be marked independent. segments
+		// numbered from the epoch can only be split at an aligned boundary.
+		p.warnf("no keyframe received for %s, splitting segment at a non-keyframe", pkt.Time-cur.Start())
+		return p.newSegment(pkt.Time, pkt.ProgramTime)
+	} else if p.primary.live() && p.primary.frag.Duration() >= fragLen-slopOffset {
 		// flush fragments periodically
 		if err := p.flush(); err != nil {
 			return err
@@ -207,11 +425,20 @@ func (p *Publisher) WriteExtendedPacket(pkt ExtendedPacket) error {
 	return nil
 }
 
-// Discontinuity inserts a marker into the playlist before the next segment indicating that the decoder should be reset
+func (p *Publisher) warnf(format string, args ...interface{}) {
+	if p.OnWarning != nil {
+		p.OnWarning(fmt.Sprintf(format, args...))
+	}
+}
+
+// Discontinuity inserts a marker into the playlist before the next segment
+// indicating that the decoder should be reset. For DASH, the next segment
+// begins a new period.
 func (p *Publisher) Discontinuity() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.nextDCN = true
+	p.nextPeriod = p.Mode != ModeSingleTrack
 }
 
 // Playlist returns the filename of the HLS main playlist
@@ -233,7 +460,6 @@ func (p *Publisher) MPD() string {
 // Close frees resources associated with the publisher
 func (p *Publisher) Close() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	p.state.Store(hlsState{})
 	for _, track := range p.tracks {
 		for _, seg := range track.segments {
@@ -242,4 +468,12 @@ func (p *Publisher) Close() {
 		track.segments = nil
 	}
 	p.notifySegment()
+	p.closeThumbnails()
+	if p.Observer != nil {
+		p.Observer.Closed()
+	}
+	p.mu.Unlock()
+	if p.Sessions != nil {
+		p.Sessions.endStream(p.Name)
+	}
 }
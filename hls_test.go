@@ -0,0 +1,80 @@
+package hls
+
+import (
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+func testStreams() []av.CodecData {
+	v := h264parser.CodecData{}
+	v.RecordInfo.AVCProfileIndication = 0x64
+	v.RecordInfo.AVCLevelIndication = 0x1f
+	v.SPSInfo.Width, v.SPSInfo.Height = 1280, 720
+	a := aacparser.CodecData{Config: aacparser.MPEG4AudioConfig{SampleRate: 48000, ChannelLayout: av.CH_STEREO, ObjectType: 2}}
+	return []av.CodecData{v, a}
+}
+
+var testEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writes 30fps video with a keyframe every 2 seconds, and AAC audio
+type feeder struct {
+	p      *Publisher
+	src    *Source // written to instead of p, if set
+	frames int
+	vt, at time.Duration
+	// frames between keyframes, if not 60
+	gop int
+	// wall clock time of the first frame, if keyframes should carry a program time
+	start time.Time
+}
+
+// write one video frame and the audio that precedes it. every stream after the first is audio.
+func (f *feeder) frame() error {
+	for f.at <= f.vt {
+		for idx := 1; idx < f.streams(); idx++ {
+			// sized so that each track's segments differ
+			if err := f.write(ExtendedPacket{Packet: av.Packet{Idx: int8(idx), Time: f.at, Data: make([]byte, 99+idx)}}); err != nil {
+				return err
+			}
+		}
+		f.at += 21333 * time.Microsecond
+	}
+	gop := f.gop
+	if gop == 0 {
+		gop = 60
+	}
+	pkt := ExtendedPacket{Packet: av.Packet{Idx: 0, Time: f.vt, IsKeyFrame: f.frames%gop == 0, Data: make([]byte, 1000)}}
+	if pkt.IsKeyFrame && !f.start.IsZero() {
+		pkt.ProgramTime = f.start.Add(f.vt)
+	}
+	f.frames++
+	f.vt = time.Duration(f.frames) * time.Second / 30
+	return f.write(pkt)
+}
+
+func (f *feeder) streams() int {
+	if f.src != nil {
+		return len(f.src.st.codecs)
+	}
+	return len(f.p.streams)
+}
+
+func (f *feeder) write(pkt ExtendedPacket) error {
+	if f.src != nil {
+		return f.src.WriteExtendedPacket(pkt)
+	}
+	return f.p.WriteExtendedPacket(pkt)
+}
+
+// write frames until the video timestamp reaches end
+func (f *feeder) until(end time.Duration) error {
+	for f.vt < end {
+		if err := f.frame(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
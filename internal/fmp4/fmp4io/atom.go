@@ -137,6 +137,10 @@ func ReadFileAtoms(r io.ReadSeeker) (atoms []Atom, err error) {
 		}
 		size := pio.U32BE(taghdr[0:])
 		tag := Tag(pio.U32BE(taghdr[4:]))
+		if size < 8 {
+			err = parseErr("TagSizeInvalid", int(offset), nil)
+			return
+		}
 
 		var atom Atom
 		switch tag {
@@ -150,6 +154,10 @@ func ReadFileAtoms(r io.ReadSeeker) (atoms []Atom, err error) {
 			atom = &MovieFrag{}
 		case SIDX:
 			atom = &SegmentIndex{}
+		case PRFT:
+			atom = &ProducerReference{}
+		case EMSG:
+			atom = &EventMessage{}
 		}
 
 		if atom != nil {
@@ -0,0 +1,147 @@
+package fmp4io
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nareix/joy4/utils/bits/pio"
+)
+
+const EMSG = Tag(0x656d7367)
+
+// EventMessage is a DASH event carried in a media segment
+type EventMessage struct {
+	FullAtom
+	SchemeIDURI string
+	Value       string
+	TimeScale   uint32
+	// PresentationTime is relative to the segment's earliest presentation time in version 0
+	PresentationTime uint64
+	EventDuration    uint32
+	ID               uint32
+	MessageData      []byte
+}
+
+func (e EventMessage) Tag() Tag {
+	return EMSG
+}
+
+func (e EventMessage) Len() (n int) {
+	n = e.FullAtom.atomLen()
+	n += len(e.SchemeIDURI) + 1
+	n += len(e.Value) + 1
+	n += 4
+	if e.Version == 0 {
+		n += 4
+	} else {
+		n += 8
+	}
+	n += 4
+	n += 4
+	n += len(e.MessageData)
+	return
+}
+
+func (e EventMessage) Marshal(b []byte) (n int) {
+	n = e.FullAtom.marshalAtom(b, EMSG)
+	if e.Version == 0 {
+		n += putCString(b[n:], e.SchemeIDURI)
+		n += putCString(b[n:], e.Value)
+		pio.PutU32BE(b[n:], e.TimeScale)
+		n += 4
+		pio.PutU32BE(b[n:], uint32(e.PresentationTime))
+		n += 4
+		pio.PutU32BE(b[n:], e.EventDuration)
+		n += 4
+		pio.PutU32BE(b[n:], e.ID)
+		n += 4
+	} else {
+		pio.PutU32BE(b[n:], e.TimeScale)
+		n += 4
+		pio.PutU64BE(b[n:], e.PresentationTime)
+		n += 8
+		pio.PutU32BE(b[n:], e.EventDuration)
+		n += 4
+		pio.PutU32BE(b[n:], e.ID)
+		n += 4
+		n += putCString(b[n:], e.SchemeIDURI)
+		n += putCString(b[n:], e.Value)
+	}
+	n += copy(b[n:], e.MessageData)
+	pio.PutU32BE(b, uint32(n))
+	return
+}
+
+func (e *EventMessage) Unmarshal(b []byte, offset int) (n int, err error) {
+	n, err = e.FullAtom.unmarshalAtom(b, offset)
+	if err != nil {
+		return
+	}
+	var m int
+	if e.Version == 0 {
+		if e.SchemeIDURI, m, err = getCString(b[n:], "SchemeIDURI", n+offset); err != nil {
+			return 0, err
+		}
+		n += m
+		if e.Value, m, err = getCString(b[n:], "Value", n+offset); err != nil {
+			return 0, err
+		}
+		n += m
+		if len(b) < n+16 {
+			return 0, parseErr("TimeScale", n+offset, nil)
+		}
+		e.TimeScale = pio.U32BE(b[n:])
+		n += 4
+		e.PresentationTime = uint64(pio.U32BE(b[n:]))
+		n += 4
+	} else {
+		if len(b) < n+20 {
+			return 0, parseErr("TimeScale", n+offset, nil)
+		}
+		e.TimeScale = pio.U32BE(b[n:])
+		n += 4
+		e.PresentationTime = pio.U64BE(b[n:])
+		n += 8
+	}
+	e.EventDuration = pio.U32BE(b[n:])
+	n += 4
+	e.ID = pio.U32BE(b[n:])
+	n += 4
+	if e.Version != 0 {
+		if e.SchemeIDURI, m, err = getCString(b[n:], "SchemeIDURI", n+offset); err != nil {
+			return 0, err
+		}
+		n += m
+		if e.Value, m, err = getCString(b[n:], "Value", n+offset); err != nil {
+			return 0, err
+		}
+		n += m
+	}
+	e.MessageData = append([]byte(nil), b[n:]...)
+	n = len(b)
+	return
+}
+
+func (e EventMessage) Children() []Atom {
+	return nil
+}
+
+func (e EventMessage) String() string {
+	return fmt.Sprintf("scheme=%q value=%q timescale=%d time=%d duration=%d id=%d data=%q",
+		e.SchemeIDURI, e.Value, e.TimeScale, e.PresentationTime, e.EventDuration, e.ID, e.MessageData)
+}
+
+func putCString(b []byte, s string) int {
+	n := copy(b, s)
+	b[n] = 0
+	return n + 1
+}
+
+// read a null-terminated string, returning its length including the terminator
+func getCString(b []byte, debug string, offset int) (string, int, error) {
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		return "", 0, parseErr(debug, offset, nil)
+	}
+	return string(b[:end]), end + 1, nil
+}
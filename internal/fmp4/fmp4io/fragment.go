@@ -174,6 +174,10 @@ func (a MovieFragHeader) Tag() Tag {
 	return MFHD
 }
 
+func (a MovieFragHeader) String() string {
+	return fmt.Sprintf("seq=%d", a.Seqnum)
+}
+
 // TRUN is the atom type for TrackFragRun
 const TRUN = Tag(0x7472756e)
 
@@ -300,10 +304,13 @@ func (a *TrackFragRun) Unmarshal(b []byte, offset int) (n int, err error) {
 	}
 	a.Flags = TrackRunFlags(pio.U24BE(b[n:]))
 	n += 3
+	if len(b) < n+4 {
+		err = parseErr("SampleCount", n+offset, err)
+		return
+	}
 	var _len_Entries uint32
 	_len_Entries = pio.U32BE(b[n:])
 	n += 4
-	a.Entries = make([]TrackFragRunEntry, _len_Entries)
 	if a.Flags&TrackRunDataOffset != 0 {
 		{
 			if len(b) < n+4 {
@@ -324,7 +331,17 @@ func (a *TrackFragRun) Unmarshal(b []byte, offset int) (n int, err error) {
 			n += 4
 		}
 	}
-
+	var entrySize int
+	for _, flag := range []TrackRunFlags{TrackRunSampleDuration, TrackRunSampleSize, TrackRunSampleFlags, TrackRunSampleCTS} {
+		if a.Flags&flag != 0 {
+			entrySize += 4
+		}
+	}
+	if int64(len(b)-n) < int64(_len_Entries)*int64(entrySize) {
+		err = parseErr("TrackFragRunEntry", n+offset, err)
+		return
+	}
+	a.Entries = make([]TrackFragRunEntry, _len_Entries)
 	for i := 0; i < int(_len_Entries); i++ {
 		entry := &a.Entries[i]
 		if a.Flags&TrackRunSampleDuration != 0 {
@@ -426,9 +443,17 @@ func (a *TrackFragDecodeTime) Unmarshal(b []byte, offset int) (n int, err error)
 	a.Flags = pio.U24BE(b[n:])
 	n += 3
 	if a.Version != 0 {
+		if len(b) < n+8 {
+			err = parseErr("Time", n+offset, err)
+			return
+		}
 		a.Time = pio.U64BE(b[n:])
 		n += 8
 	} else {
+		if len(b) < n+4 {
+			err = parseErr("Time", n+offset, err)
+			return
+		}
 		a.Time = uint64(pio.U32BE(b[n:]))
 		n += 4
 	}
@@ -443,6 +468,10 @@ func (a TrackFragDecodeTime) Tag() Tag {
 	return TFDT
 }
 
+func (a TrackFragDecodeTime) String() string {
+	return fmt.Sprintf("time=%d", a.Time)
+}
+
 const TRAF = Tag(0x74726166)
 
 type TrackFrag struct {
@@ -565,7 +594,11 @@ func (a TrackFrag) Tag() Tag {
 }
 
 func (a TrackFragRun) String() string {
-	return fmt.Sprintf("dataoffset=%d", a.DataOffset)
+	s := fmt.Sprintf("dataoffset=%d entries=%d", a.DataOffset, len(a.Entries))
+	if a.Flags&TrackRunFirstSampleFlags != 0 {
+		s += fmt.Sprintf(" firstflags=(%s)", a.FirstSampleFlags)
+	}
+	return s
 }
 
 // TFHD is the atom type for TrackFragHeader
@@ -761,5 +794,15 @@ func (a TrackFragHeader) Tag() Tag {
 }
 
 func (a TrackFragHeader) String() string {
-	return fmt.Sprintf("basedataoffset=%d", a.BaseDataOffset)
+	s := fmt.Sprintf("trackid=%d basedataoffset=%d", a.TrackID, a.BaseDataOffset)
+	if a.Flags&TrackFragDefaultDuration != 0 {
+		s += fmt.Sprintf(" defaultduration=%d", a.DefaultDuration)
+	}
+	if a.Flags&TrackFragDefaultSize != 0 {
+		s += fmt.Sprintf(" defaultsize=%d", a.DefaultSize)
+	}
+	if a.Flags&TrackFragDefaultFlags != 0 {
+		s += fmt.Sprintf(" defaultflags=(%s)", a.DefaultFlags)
+	}
+	return s
 }
@@ -0,0 +1,106 @@
+package fmp4io
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nareix/joy4/utils/bits/pio"
+)
+
+const PRFT = Tag(0x70726674)
+
+// ProducerReferenceCaptured flags a producer reference time as the time at which the sample was captured
+const ProducerReferenceCaptured = 0x18
+
+// seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+type ProducerReference struct {
+	FullAtom
+	ReferenceID uint32
+	NTPTime     uint64
+	MediaTime   uint64
+}
+
+// NTPTime converts a wall-clock time to a 64-bit NTP timestamp
+func NTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// WallClock converts a 64-bit NTP timestamp to a wall-clock time
+func WallClock(ntp uint64) time.Time {
+	secs := int64(ntp>>32) - ntpEpochOffset
+	nsec := (ntp & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nsec)).UTC()
+}
+
+func (p ProducerReference) Tag() Tag {
+	return PRFT
+}
+
+func (p ProducerReference) Len() (n int) {
+	n = p.FullAtom.atomLen()
+	n += 4
+	n += 8
+	if p.Version == 0 {
+		n += 4
+	} else {
+		n += 8
+	}
+	return
+}
+
+func (p ProducerReference) Marshal(b []byte) (n int) {
+	n = p.FullAtom.marshalAtom(b, PRFT)
+	pio.PutU32BE(b[n:], p.ReferenceID)
+	n += 4
+	pio.PutU64BE(b[n:], p.NTPTime)
+	n += 8
+	if p.Version == 0 {
+		pio.PutU32BE(b[n:], uint32(p.MediaTime))
+		n += 4
+	} else {
+		pio.PutU64BE(b[n:], p.MediaTime)
+		n += 8
+	}
+	pio.PutU32BE(b, uint32(n))
+	return
+}
+
+func (p *ProducerReference) Unmarshal(b []byte, offset int) (n int, err error) {
+	n, err = p.FullAtom.unmarshalAtom(b, offset)
+	if err != nil {
+		return
+	}
+	if len(b) < n+12 {
+		return 0, parseErr("NTPTime", n+offset, nil)
+	}
+	p.ReferenceID = pio.U32BE(b[n:])
+	n += 4
+	p.NTPTime = pio.U64BE(b[n:])
+	n += 8
+	if p.Version == 0 {
+		if len(b) < n+4 {
+			return 0, parseErr("MediaTime", n+offset, nil)
+		}
+		p.MediaTime = uint64(pio.U32BE(b[n:]))
+		n += 4
+	} else {
+		if len(b) < n+8 {
+			return 0, parseErr("MediaTime", n+offset, nil)
+		}
+		p.MediaTime = pio.U64BE(b[n:])
+		n += 8
+	}
+	return
+}
+
+func (p ProducerReference) Children() []Atom {
+	return nil
+}
+
+func (p ProducerReference) String() string {
+	return fmt.Sprintf("refid=%d ntp=%s media=%d", p.ReferenceID, WallClock(p.NTPTime).Format(time.RFC3339Nano), p.MediaTime)
+}
@@ -1,5 +1,7 @@
 package fmp4io
 
+import "fmt"
+
 type SampleFlags uint32
 
 // fragment sample flags
@@ -10,3 +12,36 @@ const (
 
 	SampleNonKeyframe = SampleHasDependencies | SampleIsNonSync
 )
+
+// IsSync returns true if the sample is a sync sample (keyframe)
+func (f SampleFlags) IsSync() bool {
+	return f&SampleIsNonSync == 0
+}
+
+func (f SampleFlags) String() string {
+	s := fmt.Sprintf("0x%08x", uint32(f))
+	if v := f >> 26 & 3; v != 0 {
+		s += fmt.Sprintf(" leading=%d", v)
+	}
+	if v := f >> 24 & 3; v != 0 {
+		s += fmt.Sprintf(" depends=%d", v)
+	}
+	if v := f >> 22 & 3; v != 0 {
+		s += fmt.Sprintf(" depended=%d", v)
+	}
+	if v := f >> 20 & 3; v != 0 {
+		s += fmt.Sprintf(" redundant=%d", v)
+	}
+	if v := f >> 17 & 7; v != 0 {
+		s += fmt.Sprintf(" padding=%d", v)
+	}
+	if f.IsSync() {
+		s += " sync"
+	} else {
+		s += " non-sync"
+	}
+	if v := f & 0xffff; v != 0 {
+		s += fmt.Sprintf(" priority=%d", v)
+	}
+	return s
+}
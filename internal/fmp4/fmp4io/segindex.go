@@ -1,6 +1,10 @@
 package fmp4io
 
-import "github.com/nareix/joy4/utils/bits/pio"
+import (
+	"fmt"
+
+	"github.com/nareix/joy4/utils/bits/pio"
+)
 
 const SIDX = Tag(0x73696478)
 
@@ -129,16 +133,16 @@ func (s *SegmentIndex) Unmarshal(b []byte, offset int) (n int, err error) {
 		if refSize&(1<<31) != 0 {
 			ref.ReferencesBox = true
 		}
-		ref.ReferencedSize = refSize &^ ((1 << 31) - 1)
+		ref.ReferencedSize = refSize & (1<<31 - 1)
 		ref.SubsegmentDuration = pio.U32BE(b[n:])
 		n += 4
-		sapDelta := pio.U32BE(b[:n])
+		sapDelta := pio.U32BE(b[n:])
 		n += 4
 		if sapDelta&(1<<31) != 0 {
 			ref.StartsWithSAP = true
 		}
 		ref.SAPType = uint8(0x7 & (sapDelta >> 28))
-		ref.SAPDeltaTime = sapDelta &^ ((1 << 28) - 1)
+		ref.SAPDeltaTime = sapDelta & (1<<28 - 1)
 	}
 	return
 }
@@ -146,3 +150,21 @@ func (s *SegmentIndex) Unmarshal(b []byte, offset int) (n int, err error) {
 func (s SegmentIndex) Children() []Atom {
 	return nil
 }
+
+func (s SegmentIndex) String() string {
+	str := fmt.Sprintf("refid=%d timescale=%d earliestpts=%d firstoffset=%d", s.ReferenceID, s.TimeScale, s.EarliestPTS, s.FirstOffset)
+	for _, ref := range s.References {
+		str += fmt.Sprintf(" [size=%d duration=%d", ref.ReferencedSize, ref.SubsegmentDuration)
+		if ref.ReferencesBox {
+			str += " sidx"
+		}
+		if ref.StartsWithSAP {
+			str += fmt.Sprintf(" sap=%d", ref.SAPType)
+		}
+		if ref.SAPDeltaTime != 0 {
+			str += fmt.Sprintf(" sapdelta=%d", ref.SAPDeltaTime)
+		}
+		str += "]"
+	}
+	return str
+}
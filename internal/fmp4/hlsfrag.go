@@ -2,7 +2,6 @@ package fmp4
 
 import (
 	"errors"
-	"fmt"
 	"sync"
 	"time"
 
@@ -27,17 +26,16 @@ type MovieFragmenter struct {
 
 // NewMovie creates a movie fragmenter from a stream
 func NewMovie(streams []av.CodecData) (*MovieFragmenter, error) {
+	tracks, err := NewTracks(streams)
+	if err != nil {
+		return nil, err
+	}
 	f := &MovieFragmenter{
-		tracks: make([]*TrackFragmenter, len(streams)),
+		tracks: tracks,
 		vidx:   -1,
 	}
 	atoms := make([]*fmp4io.Track, len(streams))
-	var err error
 	for i, cd := range streams {
-		f.tracks[i], err = NewTrack(cd)
-		if err != nil {
-			return nil, fmt.Errorf("track %d: %w", i, err)
-		}
 		atoms[i] = f.tracks[i].atom
 		if cd.Type().IsVideo() {
 			f.vidx = i
@@ -57,11 +55,15 @@ func NewMovie(streams []av.CodecData) (*MovieFragmenter, error) {
 func (f *MovieFragmenter) Fragment() (fragment.Fragment, error) {
 	dur := f.tracks[f.vidx].Duration()
 	var tracks []fragmentWithData
-	for _, track := range f.tracks {
+	var prft *fmp4io.ProducerReference
+	for i, track := range f.tracks {
 		tf := track.makeFragment()
 		if tf.trackFrag != nil {
 			tracks = append(tracks, tf)
 		}
+		if i == f.vidx {
+			prft = track.producerReference(tf)
+		}
 	}
 	if len(tracks) == 0 {
 		return fragment.Fragment{}, nil
@@ -69,7 +71,7 @@ func (f *MovieFragmenter) Fragment() (fragment.Fragment, error) {
 	f.seqNum++
 	initial := !f.shdrw
 	f.shdrw = true
-	frag := marshalFragment(tracks, f.seqNum, initial)
+	frag := marshalFragment(tracks, f.seqNum, initial, prft)
 	frag.Duration = dur
 	return frag, nil
 }
@@ -79,6 +81,18 @@ func (f *MovieFragmenter) WritePacket(pkt av.Packet) error {
 	return f.tracks[pkt.Idx].WritePacket(pkt)
 }
 
+// SetWallClock gives the wall-clock time at which the sample with timestamp ts
+// was captured. See TrackFragmenter.SetWallClock.
+func (f *MovieFragmenter) SetWallClock(wall time.Time, ts time.Duration) {
+	f.tracks[f.vidx].SetWallClock(wall, ts)
+}
+
+// SetCorrection requests that the decode times of the given track be shifted
+// gradually by the given amount. See TrackFragmenter.SetCorrection.
+func (f *MovieFragmenter) SetCorrection(idx int, d time.Duration) {
+	f.tracks[idx].SetCorrection(d)
+}
+
 // Duration calculates the elapsed duration between the first and last pending video frame
 func (f *MovieFragmenter) Duration() time.Duration {
 	return f.tracks[f.vidx].Duration()
@@ -34,7 +34,7 @@ func (f *TrackFragmenter) makeFragment() fragmentWithData {
 		},
 		DecodeTime: &fmp4io.TrackFragDecodeTime{
 			Version: 1,
-			Time:    startDTS,
+			Time:    f.shifted(startDTS),
 		},
 		Run: &fmp4io.TrackFragRun{
 			Flags:   fmp4io.TrackRunDataOffset,
@@ -47,8 +47,14 @@ func (f *TrackFragmenter) makeFragment() fragmentWithData {
 		// calculate the absolute DTS of the next sample and use the difference as the duration
 		nextTime := f.pending[i+1].Time
 		nextDTS := timescale.ToScale(nextTime, f.timeScale)
+		duration := int64(nextDTS - curDTS)
+		if step := f.correctionStep(duration); step != 0 {
+			// stretch or shrink the sample to gradually apply the requested correction
+			duration += step
+			f.shift += step
+		}
 		entry := fmp4io.TrackFragRunEntry{
-			Duration: uint32(nextDTS - curDTS),
+			Duration: uint32(duration),
 			Flags:    defaultFlags,
 			Size:     uint32(len(pkt.Data)),
 		}
@@ -122,7 +128,34 @@ func (f *TrackFragmenter) makeFragment() fragmentWithData {
 	return d
 }
 
-func marshalFragment(tracks []fragmentWithData, seqNum uint32, initial bool) fragment.Fragment {
+// apply the accumulated timestamp correction to a decode time
+func (f *TrackFragmenter) shifted(dts uint64) uint64 {
+	if f.shift < 0 && uint64(-f.shift) > dts {
+		return 0
+	}
+	return uint64(int64(dts) + f.shift)
+}
+
+// calculate how much to adjust the duration of the next sample to move towards the requested correction
+func (f *TrackFragmenter) correctionStep(duration int64) int64 {
+	return fragment.CorrectionStep(f.shiftGoal-f.shift, duration)
+}
+
+// relate the fragment's first sample to the wall clock, if a reference has been set
+func (f *TrackFragmenter) producerReference(tf fragmentWithData) *fmp4io.ProducerReference {
+	if f.refWall.IsZero() || tf.trackFrag == nil {
+		return nil
+	}
+	wall := f.refWall.Add(tf.packets[0].Time - f.refTime)
+	return &fmp4io.ProducerReference{
+		FullAtom:    fmp4io.FullAtom{Version: 1, Flags: fmp4io.ProducerReferenceCaptured},
+		ReferenceID: f.trackID,
+		NTPTime:     fmp4io.NTPTime(wall),
+		MediaTime:   tf.trackFrag.DecodeTime.Time,
+	}
+}
+
+func marshalFragment(tracks []fragmentWithData, seqNum uint32, initial bool, prft *fmp4io.ProducerReference) fragment.Fragment {
 	// fill out fragment header
 	moof := &fmp4io.MovieFrag{
 		Header: &fmp4io.MovieFragHeader{
@@ -154,12 +187,19 @@ func marshalFragment(tracks []fragmentWithData, seqNum uint32, initial bool) fra
 		})
 		shdrSize = len(shdr)
 	}
+	if prft != nil {
+		// the producer reference precedes the MOOF it describes
+		shdrSize += prft.Len()
+	}
 	b := make([]byte, shdrSize+dataBase, shdrSize+dataOffset)
 	var n int
 	if initial {
 		copy(b, shdr)
 		n = len(shdr)
 	}
+	if prft != nil {
+		n += prft.Marshal(b[n:])
+	}
 	n += moof.Marshal(b[n:])
 	pio.PutU32BE(b[n:], uint32(dataOffset-dataBase+8))
 	pio.PutU32BE(b[n+4:], uint32(fmp4io.MDAT))
@@ -0,0 +1,37 @@
+package fmp4
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+// Snapshot builds a standalone MP4 holding a single frame, which should be a keyframe
+func Snapshot(codecData av.CodecData, pkt av.Packet, duration time.Duration) ([]byte, error) {
+	f, err := NewTrack(codecData)
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		duration = time.Second / 30
+	}
+	// the fragmenter needs the next packet's timestamp to calculate the duration
+	pkt.Time = 0
+	pkt.CompositionTime = 0
+	if err := f.WritePacket(pkt); err != nil {
+		return nil, err
+	}
+	f.pending = append(f.pending, av.Packet{Time: duration})
+	// the fragment directly follows the movie header, without a segment type box
+	f.shdrw = true
+	frag, err := f.Fragment()
+	if err != nil {
+		return nil, err
+	} else if frag.Bytes == nil {
+		return nil, errors.New("failed to produce fragment")
+	}
+	b := make([]byte, 0, len(f.fhdr)+len(frag.Bytes))
+	b = append(b, f.fhdr...)
+	return append(b, frag.Bytes...), nil
+}
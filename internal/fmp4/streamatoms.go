@@ -5,6 +5,7 @@ import (
 
 	"eaglesong.dev/hls/internal/fmp4/esio"
 	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+	"eaglesong.dev/hls/internal/fragment"
 	"github.com/nareix/joy4/av"
 	"github.com/nareix/joy4/codec/aacparser"
 	"github.com/nareix/joy4/codec/h264parser"
@@ -160,3 +161,23 @@ func FragmentHeader() []byte {
 	styp.Marshal(shdr)
 	return shdr
 }
+
+// MergeHeaders builds an initialization segment describing all of the given
+// tracks, so that their fragments can be played back as a single stream. The
+// tracks must have distinct IDs, as assigned by NewTracks.
+func MergeHeaders(frags []fragment.Fragmenter) ([]byte, error) {
+	atoms := make([]*fmp4io.Track, len(frags))
+	seen := make(map[uint32]int, len(frags))
+	for i, frag := range frags {
+		tf, ok := frag.(*TrackFragmenter)
+		if !ok {
+			return nil, fmt.Errorf("track %d: can't merge header from %T", i, frag)
+		}
+		if j, ok := seen[tf.trackID]; ok {
+			return nil, fmt.Errorf("track %d: track ID %d is already used by track %d", i, tf.trackID, j)
+		}
+		seen[tf.trackID] = i
+		atoms[i] = tf.atom
+	}
+	return MovieHeader(atoms)
+}
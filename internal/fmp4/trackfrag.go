@@ -1,6 +1,7 @@
 package fmp4
 
 import (
+	"fmt"
 	"time"
 
 	"eaglesong.dev/hls/internal/fmp4/fmp4io"
@@ -17,6 +18,14 @@ type TrackFragmenter struct {
 	atom      *fmp4io.Track
 	pending   []av.Packet
 
+	// timestamp correction, in timescale units
+	shift     int64 // applied so far
+	shiftGoal int64 // requested
+
+	// wall-clock time of a reference timestamp, for producer reference boxes
+	refWall time.Time
+	refTime time.Duration
+
 	// for CMAF (single track) only
 	seqNum uint32
 	fhdr   []byte
@@ -29,6 +38,37 @@ func NewTrack(codecData av.CodecData) (*TrackFragmenter, error) {
 	if codecData.Type().IsVideo() {
 		trackID = 2
 	}
+	return newTrack(codecData, trackID)
+}
+
+// NewTracks creates a fragmenter for each of the given streams. Each track
+// gets a distinct ID so that their fragments can be combined into one movie.
+func NewTracks(streams []av.CodecData) ([]*TrackFragmenter, error) {
+	frags := make([]*TrackFragmenter, len(streams))
+	var audio, video bool
+	nextID := uint32(3)
+	for i, cd := range streams {
+		// the first audio and video tracks keep the same IDs as a lone track
+		var trackID uint32
+		switch {
+		case cd.Type().IsVideo() && !video:
+			trackID, video = 2, true
+		case cd.Type().IsAudio() && !audio:
+			trackID, audio = 1, true
+		default:
+			trackID = nextID
+			nextID++
+		}
+		var err error
+		frags[i], err = newTrack(cd, trackID)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", i, err)
+		}
+	}
+	return frags, nil
+}
+
+func newTrack(codecData av.CodecData, trackID uint32) (*TrackFragmenter, error) {
 	f := &TrackFragmenter{
 		codecData: codecData,
 		trackID:   trackID,
@@ -77,6 +117,21 @@ func (f *TrackFragmenter) TimeScale() uint32 {
 	return f.timeScale
 }
 
+// SetCorrection requests that the track's decode times be shifted by the
+// given amount relative to the packet timestamps. The shift is applied
+// gradually by lengthening or shortening each sample by up to 1%.
+func (f *TrackFragmenter) SetCorrection(d time.Duration) {
+	f.shiftGoal = int64(d) * int64(f.timeScale) / int64(time.Second)
+}
+
+// SetWallClock gives the wall-clock time at which the sample with timestamp ts
+// was captured. Subsequent fragments are preceded by a producer reference
+// (prft) box relating their first sample to the wall clock.
+func (f *TrackFragmenter) SetWallClock(wall time.Time, ts time.Duration) {
+	f.refWall = wall
+	f.refTime = ts
+}
+
 // Fragment produces a fragment out of the currently-queued packets.
 func (f *TrackFragmenter) Fragment() (fragment.Fragment, error) {
 	dur := f.Duration()
@@ -88,7 +143,7 @@ func (f *TrackFragmenter) Fragment() (fragment.Fragment, error) {
 	f.seqNum++
 	initial := !f.shdrw
 	f.shdrw = true
-	frag := marshalFragment([]fragmentWithData{tf}, f.seqNum, initial)
+	frag := marshalFragment([]fragmentWithData{tf}, f.seqNum, initial, f.producerReference(tf))
 	frag.Duration = dur
 	return frag, nil
 }
@@ -29,3 +29,22 @@ type Fragmenter interface {
 	Header() Header
 	NewSegment()
 }
+
+// MaxCorrectionRatio limits timestamp corrections: samples may be lengthened or
+// shortened by at most 1/MaxCorrectionRatio of their duration
+const MaxCorrectionRatio = 100
+
+// CorrectionStep calculates how much to adjust the duration of the next sample
+// to move towards the remaining correction, in the same units as duration
+func CorrectionStep(remaining, duration int64) int64 {
+	if remaining == 0 || duration <= 0 {
+		return 0
+	}
+	maxStep := duration / MaxCorrectionRatio
+	if remaining > maxStep {
+		return maxStep
+	} else if remaining < -maxStep {
+		return -maxStep
+	}
+	return remaining
+}
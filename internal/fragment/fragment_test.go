@@ -0,0 +1,23 @@
+package fragment
+
+import "testing"
+
+func TestCorrectionStep(t *testing.T) {
+	values := []struct {
+		Remaining, Duration, Step int64
+	}{
+		{0, 1000, 0},
+		{5, 1000, 5},
+		{-5, 1000, -5},
+		{50, 1000, 10},
+		{-50, 1000, -10},
+		{50, 99, 0},
+		{50, 0, 0},
+		{50, -1000, 0},
+	}
+	for _, ex := range values {
+		if step := CorrectionStep(ex.Remaining, ex.Duration); step != ex.Step {
+			t.Errorf("remaining %d over %d: expected %d, got %d", ex.Remaining, ex.Duration, ex.Step, step)
+		}
+	}
+}
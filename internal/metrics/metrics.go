@@ -0,0 +1,186 @@
+// Package metrics implements counters and histograms and writes them in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a value that only increases. The zero value is ready to use.
+type Counter struct {
+	v int64
+}
+
+// Add n to the counter
+func (c *Counter) Add(n int64) { atomic.AddInt64(&c.v, n) }
+
+// Inc adds one to the counter
+func (c *Counter) Inc() { atomic.AddInt64(&c.v, 1) }
+
+// Value returns the current count
+func (c *Counter) Value() int64 { return atomic.LoadInt64(&c.v) }
+
+// Gauge is a value that can go up and down. The zero value is ready to use.
+type Gauge struct {
+	v int64
+}
+
+// Add n to the gauge, which may be negative
+func (g *Gauge) Add(n int64) { atomic.AddInt64(&g.v, n) }
+
+// Value returns the current value
+func (g *Gauge) Value() int64 { return atomic.LoadInt64(&g.v) }
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	bounds []float64
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds, which must be in increasing order
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Labels formats label pairs, given as alternating names and values
+func Labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escaper.Replace(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Set collects samples from multiple sources so that each metric family is written together
+type Set struct {
+	families []*family
+	byName   map[string]*family
+}
+
+type family struct {
+	name, help, typ string
+	lines           []string
+}
+
+func (s *Set) family(name, help, typ string) *family {
+	if f := s.byName[name]; f != nil {
+		return f
+	}
+	if s.byName == nil {
+		s.byName = make(map[string]*family)
+	}
+	f := &family{name: name, help: help, typ: typ}
+	s.byName[name] = f
+	s.families = append(s.families, f)
+	return f
+}
+
+// Counter adds a counter sample
+func (s *Set) Counter(name, help, labels string, v int64) {
+	f := s.family(name, help, "counter")
+	f.lines = append(f.lines, name+labels+" "+strconv.FormatInt(v, 10))
+}
+
+// Gauge adds a gauge sample
+func (s *Set) Gauge(name, help, labels string, v float64) {
+	f := s.family(name, help, "gauge")
+	f.lines = append(f.lines, name+labels+" "+formatFloat(v))
+}
+
+// Histogram adds the buckets, sum and count of a histogram
+func (s *Set) Histogram(name, help, labels string, h *Histogram) {
+	f := s.family(name, help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		f.lines = append(f.lines, name+"_bucket"+withLabel(labels, "le", formatFloat(bound))+" "+strconv.FormatUint(h.counts[i], 10))
+	}
+	f.lines = append(f.lines,
+		name+"_bucket"+withLabel(labels, "le", "+Inf")+" "+strconv.FormatUint(h.count, 10),
+		name+"_sum"+labels+" "+formatFloat(h.sum),
+		name+"_count"+labels+" "+strconv.FormatUint(h.count, 10),
+	)
+}
+
+// WriteTo writes all collected samples
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	b := bufio.NewWriter(cw)
+	for _, f := range s.families {
+		b.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		b.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+		for _, line := range f.lines {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	err := b.Flush()
+	return cw.n, err
+}
+
+// add a label to an existing label set
+func withLabel(labels, name, value string) string {
+	l := Labels(name, value)
+	if labels == "" {
+		return l
+	}
+	return labels[:len(labels)-1] + "," + l[1:]
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(d []byte) (int, error) {
+	n, err := c.w.Write(d)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestGolden(t *testing.T) {
+	var c Counter
+	c.Add(1500)
+	c.Inc()
+	var g Gauge
+	g.Add(3)
+	g.Add(-1)
+	h := NewHistogram(0.5, 1, 2.5)
+	for _, v := range []float64{0.25, 0.5, 0.75, 2, 10} {
+		h.Observe(v)
+	}
+	var s Set
+	s.Counter("test_bytes_total", "Bytes counted.", Labels("stream", "a"), c.Value())
+	s.Gauge("test_viewers", "Current viewers.", "", float64(g.Value()))
+	s.Histogram("test_seconds", "Time taken.", Labels("stream", "a"), h)
+	// samples of the same family are written together under one HELP and TYPE
+	s.Counter("test_bytes_total", "Bytes counted.", Labels("stream", `b"\`+"\n"), 0)
+	s.Histogram("test_seconds", "Time taken.", Labels("stream", "b"), NewHistogram(0.5, 1, 2.5))
+	var buf bytes.Buffer
+	n, err := s.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Errorf("wrote %d bytes but returned %d", buf.Len(), n)
+	}
+	got := buf.Bytes()
+	const golden = "testdata/set.golden"
+	if *update {
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("output does not match %s:\n%s", golden, got)
+	}
+}
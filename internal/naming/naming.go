@@ -0,0 +1,170 @@
+// Package naming builds the filenames of playlists and segments from a template and routes requests back to them
+package naming
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default template which produces names like "0abc12345.3.m4s"
+const Default = "{track}{pid}{msn}{part}{ext}"
+
+// Name identifies a file belonging to one track of a stream
+type Name struct {
+	Track int
+	// MSN is the segment number, "t" and a presentation time for a segment
+	// addressed by time, "init" or "initN" for an initialization segment, or
+	// empty for a media playlist
+	MSN string
+	// Part is the part number or -1 for a whole segment
+	Part int
+	// Ext is the file extension including the leading dot
+	Ext string
+}
+
+// Template formats and parses names for a particular stream instance
+type Template struct {
+	tmpl string
+	pid  string
+	re   *regexp.Regexp
+	keys []string
+}
+
+var placeholders = map[string]string{
+	"{track}": `(\d+)`,
+	"{msn}":   `(\d*|t\d+|init\d*)`,
+	"{part}":  `((?:\.\d+)?)`,
+	"{ext}":   `(\.[a-z0-9]+)`,
+}
+
+// Compile a template for the stream instance with the given pid.
+//
+// The template must contain {track}, {msn} and {ext}. {pid} and {part} are
+// optional, but without {pid} a restarted stream reuses the names of the
+// previous one and without {part} individual parts can't be addressed.
+// {track} and {msn} are numeric, so each must be separated from the other by
+// something other than a digit. {pid} is a separator if it contains a letter.
+func Compile(tmpl, pid string) (*Template, error) {
+	if tmpl == "" {
+		tmpl = Default
+	}
+	t := &Template{tmpl: tmpl, pid: pid}
+	expr := `(?:^|/)`
+	rest := tmpl
+	var numeric string // preceding numeric placeholder, if not yet separated
+	for rest != "" {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			expr += regexp.QuoteMeta(rest)
+			break
+		}
+		if strings.IndexFunc(rest[:i], isNotDigit) >= 0 {
+			numeric = ""
+		}
+		expr += regexp.QuoteMeta(rest[:i])
+		rest = rest[i:]
+		j := strings.IndexByte(rest, '}')
+		if j < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in URL template %q", tmpl)
+		}
+		key := rest[:j+1]
+		rest = rest[j+1:]
+		switch key {
+		case "{pid}":
+			if strings.IndexFunc(pid, isNotDigit) >= 0 {
+				numeric = ""
+				break
+			}
+			fallthrough
+		case "{track}", "{msn}":
+			if numeric != "" {
+				return nil, fmt.Errorf("URL template %q must separate %s and %s with a non-digit", tmpl, numeric, key)
+			}
+			numeric = key
+		case "{ext}":
+			// always begins with a dot
+			numeric = ""
+		}
+		if key == "{pid}" {
+			expr += regexp.QuoteMeta(pid)
+			continue
+		}
+		sub, ok := placeholders[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder %s in URL template %q", key, tmpl)
+		}
+		t.keys = append(t.keys, key)
+		expr += sub
+	}
+	for _, key := range []string{"{track}", "{msn}", "{ext}"} {
+		if !strings.Contains(tmpl, key) {
+			return nil, errors.New("URL template must contain " + key)
+		}
+	}
+	re, err := regexp.Compile(expr + "$")
+	if err != nil {
+		return nil, err
+	}
+	t.re = re
+	return t, nil
+}
+
+// Format the filename for n
+func (t *Template) Format(n Name) string {
+	var part string
+	if n.Part >= 0 {
+		part = "." + strconv.Itoa(n.Part)
+	}
+	return strings.NewReplacer(
+		"{track}", strconv.Itoa(n.Track),
+		"{pid}", t.pid,
+		"{msn}", n.MSN,
+		"{part}", part,
+		"{ext}", n.Ext,
+	).Replace(t.tmpl)
+}
+
+// Match parses a request path. The path may have any prefix as long as the name begins at a directory boundary.
+func (t *Template) Match(p string) (n Name, ok bool) {
+	m := t.re.FindStringSubmatch(p)
+	if m == nil {
+		return n, false
+	}
+	n.Part = -1
+	for i, key := range t.keys {
+		v := m[i+1]
+		switch key {
+		case "{track}":
+			track, err := strconv.Atoi(v)
+			if err != nil {
+				return n, false
+			}
+			n.Track = track
+		case "{msn}":
+			n.MSN = v
+		case "{part}":
+			if v != "" {
+				part, err := strconv.Atoi(v[1:])
+				if err != nil {
+					return n, false
+				}
+				n.Part = part
+			}
+		case "{ext}":
+			n.Ext = v
+		}
+	}
+	return n, true
+}
+
+func isNotDigit(r rune) bool {
+	return r < '0' || r > '9'
+}
+
+// Rel returns the URL of target relative to the file at from. Both must be relative to the same root.
+func Rel(from, target string) string {
+	return strings.Repeat("../", strings.Count(from, "/")) + target
+}
@@ -0,0 +1,95 @@
+package naming
+
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	values := []struct {
+		Tmpl string
+		N    Name
+		V    string
+	}{
+		{"", Name{Track: 0, MSN: "123", Part: -1, Ext: ".m4s"}, "0t85s00123.m4s"},
+		{"", Name{Track: 12, MSN: "123", Part: 4, Ext: ".m4s"}, "12t85s00123.4.m4s"},
+		{"", Name{Track: 0, MSN: "t900000", Part: -1, Ext: ".m4s"}, "0t85s00t900000.m4s"},
+		{"", Name{Track: 1, MSN: "init", Part: -1, Ext: ".mp4"}, "1t85s00init.mp4"},
+		{"", Name{Track: 1, MSN: "init2", Part: -1, Ext: ".mp4"}, "1t85s00init2.mp4"},
+		{"", Name{Track: 3, Part: -1, Ext: ".m3u8"}, "3t85s00.m3u8"},
+		{"{track}-{pid}-{msn}{part}{ext}", Name{Track: 11, MSN: "123", Part: 4, Ext: ".m4s"}, "11-t85s00-123.4.m4s"},
+		{"{pid}/{track}/{msn}{part}{ext}", Name{Track: 10, MSN: "7", Part: 0, Ext: ".m4s"}, "t85s00/10/7.0.m4s"},
+		{"{pid}/{track}/{msn}{part}{ext}", Name{Track: 2, Part: -1, Ext: ".m3u8"}, "t85s00/2/.m3u8"},
+		{"{track}/{pid}/{msn}{part}{ext}", Name{Track: 11, MSN: "5", Part: 3, Ext: ".m4s"}, "11/t85s00/5.3.m4s"},
+		{"{track}/{pid}/{msn}{part}{ext}", Name{Track: 11, MSN: "init", Part: -1, Ext: ".mp4"}, "11/t85s00/init.mp4"},
+	}
+	for _, ex := range values {
+		tmpl, err := Compile(ex.Tmpl, "t85s00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := tmpl.Format(ex.N)
+		if v != ex.V {
+			t.Errorf("%q %+v: expected %q, got %q", ex.Tmpl, ex.N, ex.V, v)
+		}
+		n, ok := tmpl.Match("/stream/" + v)
+		if !ok {
+			t.Errorf("%q: %q did not match", ex.Tmpl, v)
+		} else if n != ex.N {
+			t.Errorf("%q: %q expected %+v, got %+v", ex.Tmpl, v, ex.N, n)
+		}
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	tmpl, err := Compile("", "t85s00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"main.m3u8", "0-abc-123.m4s", "0-t85s00-123.x.m4s", "time"} {
+		if n, ok := tmpl.Match(v); ok {
+			t.Errorf("%q: expected no match, got %+v", v, n)
+		}
+	}
+	if _, err := Compile("{track}{bogus}{msn}{ext}", "x"); err == nil {
+		t.Error("expected error for unknown placeholder")
+	}
+	if _, err := Compile("{track}-{pid}{ext}", "x"); err == nil {
+		t.Error("expected error for missing {msn}")
+	}
+	if _, err := Compile("{track}/{pid}/{msn}.{part}.m4s", "x"); err == nil {
+		t.Error("expected error for missing {ext}, which playlists need")
+	}
+	for _, v := range []string{"{track}{msn}{part}{ext}", "{track}-{msn}{part}{track}{ext}", "{track}0{msn}{ext}"} {
+		if _, err := Compile(v, "x"); err == nil {
+			t.Errorf("%q: expected error for unseparated numbers", v)
+		}
+	}
+	// a numeric pid doesn't separate anything
+	for _, v := range []string{"", "{track}{pid}/{msn}{ext}", "{track}/{pid}{msn}{ext}"} {
+		if _, err := Compile(v, "123"); err == nil {
+			t.Errorf("%q: expected error for unseparated numbers", v)
+		}
+	}
+}
+
+func TestNumericPID(t *testing.T) {
+	tmpl, err := Compile("{track}-{pid}-{msn}{part}{ext}", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Name{Track: 10, MSN: "0", Part: -1, Ext: ".m4s"}
+	v := tmpl.Format(expected)
+	if n, ok := tmpl.Match(v); !ok || n != expected {
+		t.Errorf("%q: expected %+v, got %+v", v, expected, n)
+	}
+	if n, ok := tmpl.Match("100.m4s"); ok {
+		t.Errorf("expected no match, got %+v", n)
+	}
+}
+
+func TestRel(t *testing.T) {
+	if v := Rel("0x.m3u8", "0x1.m4s"); v != "0x1.m4s" {
+		t.Errorf("expected 0x1.m4s, got %q", v)
+	}
+	if v := Rel("x/0/.m3u8", "x/0/1.m4s"); v != "../../x/0/1.m4s" {
+		t.Errorf("expected ../../x/0/1.m4s, got %q", v)
+	}
+}
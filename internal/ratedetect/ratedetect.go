@@ -1,8 +1,6 @@
 package ratedetect
 
 import (
-	"encoding/xml"
-	"fmt"
 	"math"
 	"time"
 )
@@ -63,20 +61,3 @@ type Rate struct {
 	// Float value of rate
 	Float float64
 }
-
-// MarshalXMLAttr formats the frame rate as an integer, ratio or float for DASH manifests
-func (r Rate) MarshalXMLAttr(name xml.Name) (attr xml.Attr, err error) {
-	if r.Numerator == 0 && r.Float == 0 {
-		return
-	}
-	attr.Name = name
-	switch r.Denominator {
-	case 0:
-		attr.Value = fmt.Sprintf("%.2f", r.Float)
-	case 1:
-		attr.Value = fmt.Sprintf("%d", r.Numerator)
-	default:
-		attr.Value = fmt.Sprintf("%d/%d", r.Numerator, r.Denominator)
-	}
-	return
-}
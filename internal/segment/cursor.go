@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"eaglesong.dev/hls/internal/fragment"
 )
 
 const (
@@ -46,8 +48,10 @@ func (c *Cursor) Serve(rw http.ResponseWriter, req *http.Request, part int, data
 	} else {
 		// serve whole segment
 		if c.s.final {
-			// from file
-			r = c.s.f
+			// from file, unless it is a gap or has been released
+			if c.s.f != nil {
+				r = c.s.f
+			}
 		} else {
 			// trickle fragments
 			c.s.trickleLocked(rw, req)
@@ -71,6 +75,69 @@ func (c *Cursor) Serve(rw http.ResponseWriter, req *http.Request, part int, data
 	return true
 }
 
+// Progress returns how many parts are in the segment and whether it is complete
+func (c *Cursor) Progress() (parts int, final bool) {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+	return len(c.s.parts), c.s.final
+}
+
+// Gap returns whether the segment is a placeholder with no media
+func (c *Cursor) Gap() bool {
+	return c.s.gap
+}
+
+// Start returns the time at which the segment begins
+func (c *Cursor) Start() time.Duration {
+	return c.s.start
+}
+
+// Duration returns the duration of the segment, or of the parts so far if it is incomplete
+func (c *Cursor) Duration() time.Duration {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+	if c.s.final {
+		return c.s.dur
+	}
+	var dur time.Duration
+	for _, p := range c.s.parts {
+		dur += p.Duration
+	}
+	return dur
+}
+
+// ProgramTime returns the wall-clock time at which the segment begins, if known
+func (c *Cursor) ProgramTime() time.Time {
+	return c.s.programTime
+}
+
+// PartInfo describes a part without its contents
+func (c *Cursor) PartInfo(part int) (info fragment.Fragment, ok bool) {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+	if part < 0 || part >= len(c.s.parts) {
+		return info, false
+	}
+	info = c.s.parts[part]
+	info.Bytes = nil
+	return info, true
+}
+
+// Part returns the contents of a part, or nil if it isn't available
+func (c *Cursor) Part(part int) []byte {
+	c.s.mu.RLock()
+	r := c.s.readPartLocked(part)
+	c.s.mu.RUnlock()
+	if r == nil {
+		return nil
+	}
+	d, err := io.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	return d
+}
+
 // get a reader for the complete part or segment
 func (s *Segment) readPartLocked(part int) io.ReadSeeker {
 	if part >= len(s.parts) {
@@ -1,37 +1,5 @@
 package segment
 
-import (
-	"strconv"
-	"strings"
-)
-
-// ParseName extracts the MSN and part number from a filename
-func ParseName(name string) (id PartMSN, ok bool) {
-	i := strings.LastIndexByte(name, '.')
-	if i < 0 {
-		return
-	}
-	name = name[:i]
-	id.Part = -1
-	if k := strings.IndexByte(name, '.'); k > 0 {
-		part, err := strconv.ParseInt(name[k+1:], 10, 0)
-		if err != nil {
-			return
-		}
-		id.Part = int(part)
-		name = name[:k]
-	}
-	num, err := strconv.ParseInt(name, 10, 64)
-	if err != nil {
-		return
-	}
-	id.MSN = MSN(num)
-	if id.MSN < 0 {
-		return
-	}
-	return id, true
-}
-
 // MSN is a Media Sequence Number, it starts at 0 for the first segment and
 // increments for every subsequent segment.
 type MSN int
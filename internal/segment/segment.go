@@ -1,25 +1,26 @@
 package segment
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
+	"io"
 	"os"
-	"strings"
+	"path"
 	"sync"
 	"time"
 
 	"eaglesong.dev/hls/internal/fragment"
+	"eaglesong.dev/hls/m3u8"
 )
 
 // Segment holds a single HLS segment which can be written to in parts
 //
 // Methods of Segment are not safe for concurrent use. Use Cursor() to get a concurrent accessor.
 type Segment struct {
-	base, suf   string
+	names       func(part int) string
 	start       time.Duration
 	dcn         bool
-	programTime string
+	gap         bool
+	programTime time.Time
 	ctype       string
 	// modified while the segment is live
 	mu    sync.RWMutex
@@ -32,22 +33,21 @@ type Segment struct {
 	dur   time.Duration
 }
 
-// New creates a new HLS segment
-func New(name, workDir, ctype string, start time.Duration, dcn bool, programTime time.Time) (*Segment, error) {
-	i := strings.LastIndexByte(name, '.')
-	if i < 0 {
+// New creates a new HLS segment. names gives the URI of each part, or of the whole segment if part is -1.
+func New(names func(part int) string, workDir, ctype string, start time.Duration, dcn bool, programTime time.Time) (*Segment, error) {
+	name := path.Base(names(-1))
+	if name == "" || name == "." || name == "/" {
 		return nil, errors.New("invalid segment basename")
 	}
 	s := &Segment{
-		base:  name[:i],
-		suf:   name[i:],
+		names: names,
 		ctype: ctype,
 		start: start,
 		dcn:   dcn,
 	}
 	s.cond.L = s.mu.RLocker()
 	if !programTime.IsZero() {
-		s.programTime = programTime.UTC().Format("2006-01-02T15:04:05.999Z07:00")
+		s.programTime = programTime.UTC()
 	}
 	var err error
 	s.f, err = os.CreateTemp(workDir, name)
@@ -58,6 +58,19 @@ func New(name, workDir, ctype string, start time.Duration, dcn bool, programTime
 	return s, nil
 }
 
+// NewGap creates a finalized segment with no media, which holds the place of a
+// segment number that was skipped
+func NewGap(names func(part int) string, start time.Duration) *Segment {
+	s := &Segment{
+		names: names,
+		start: start,
+		gap:   true,
+		final: true,
+	}
+	s.cond.L = s.mu.RLocker()
+	return s
+}
+
 // Append a complete fragment to the segment. The buffer must not be modified afterwards.
 func (s *Segment) Append(frag fragment.Fragment) error {
 	s.mu.Lock()
@@ -73,6 +86,24 @@ func (s *Segment) Append(frag fragment.Fragment) error {
 // Discontinuous returns whether the segment immediately follows a change in stream parameters
 func (s *Segment) Discontinuous() bool { return s.dcn }
 
+// Gap returns whether the segment is a placeholder with no media
+func (s *Segment) Gap() bool { return s.gap }
+
+// Independent returns whether the segment begins with a keyframe
+func (s *Segment) Independent() bool {
+	return len(s.parts) != 0 && s.parts[0].Independent
+}
+
+// Contents returns a reader for a finalized segment, which remains valid until the segment is released
+func (s *Segment) Contents() *io.SectionReader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.final || s.f == nil {
+		return nil
+	}
+	return io.NewSectionReader(s.f, 0, s.size)
+}
+
 // Duration returns the duration of the segment if it has been finalized
 func (s *Segment) Duration() time.Duration { return s.dur }
 
@@ -82,6 +113,17 @@ func (s *Segment) Final() bool { return s.final }
 // Parts returns how many parts are currently in the segment
 func (s *Segment) Parts() int { return len(s.parts) }
 
+// LongestPart returns the duration of the longest part currently in the segment
+func (s *Segment) LongestPart() time.Duration {
+	var longest time.Duration
+	for _, part := range s.parts {
+		if part.Duration > longest {
+			longest = part.Duration
+		}
+	}
+	return longest
+}
+
 // Size returns how many bytes are currently in the segment
 func (s *Segment) Size() int64 { return s.size }
 
@@ -90,6 +132,11 @@ func (s *Segment) Start() time.Duration {
 	return s.start
 }
 
+// ProgramTime returns the wall-clock time at which the segment begins, if known
+func (s *Segment) ProgramTime() time.Time {
+	return s.programTime
+}
+
 // Finalize a live segment, marking that no more parts will be added
 func (s *Segment) Finalize(nextSegment time.Duration) {
 	s.mu.Lock()
@@ -111,36 +158,43 @@ func (s *Segment) Release() {
 	s.mu.Lock()
 	s.parts = nil
 	s.size = 0
-	s.f.Close()
-	s.f = nil
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
 	s.mu.Unlock()
 	s.cond.Broadcast()
 }
 
-// Format a playlist fragment for this segment
-func (s *Segment) Format(b *bytes.Buffer, includeParts bool) {
+// PlaylistEntry describes this segment for a media playlist. It returns false
+// if the segment is incomplete and has no parts to list.
+//
+// If header is not empty then the segment is preceded by a reference to that initialization segment.
+func (s *Segment) PlaylistEntry(header string, includeParts bool) (entry m3u8.Segment, ok bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	if !s.final && (!includeParts || len(s.parts) == 0) {
-		return
-	}
-	if s.programTime != "" {
-		fmt.Fprintf(b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", s.programTime)
+		return entry, false
 	}
-	if s.dcn {
-		b.WriteString("#EXT-X-DISCONTINUITY\n")
+	entry.ProgramDateTime = s.programTime
+	entry.Discontinuity = s.dcn
+	entry.Gap = s.gap
+	if header != "" {
+		entry.Map = &m3u8.Map{URI: header}
 	}
 	if includeParts {
+		entry.Parts = make([]m3u8.Part, len(s.parts))
 		for i, part := range s.parts {
-			var independent string
-			if part.Independent {
-				independent = "INDEPENDENT=YES,"
+			entry.Parts[i] = m3u8.Part{
+				URI:         s.names(i),
+				Duration:    part.Duration,
+				Independent: part.Independent,
 			}
-			fmt.Fprintf(b, "#EXT-X-PART:DURATION=%f,%sURI=\"%s.%d%s\"\n",
-				part.Duration.Seconds(), independent, s.base, i, s.suf)
 		}
 	}
 	if s.final {
-		fmt.Fprintf(b, "#EXTINF:%f,\n%s%s\n", s.dur.Seconds(), s.base, s.suf)
+		entry.URI = s.names(-1)
+		entry.Duration = s.dur
 	}
+	return entry, true
 }
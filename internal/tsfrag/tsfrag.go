@@ -14,14 +14,24 @@ type Fragmenter struct {
 	mux     *ts.Muxer
 	pending []av.Packet
 	vidx    int
+	shifts  []shift
 
 	shdr []byte
 	shdw bool
 }
 
+// timestamp correction of a single stream
+type shift struct {
+	applied time.Duration
+	goal    time.Duration
+	last    time.Duration // original timestamp of the previous packet
+	started bool
+}
+
 func New(streams []av.CodecData) (*Fragmenter, error) {
 	f := new(Fragmenter)
 	f.mux = ts.NewMuxer(&f.buf)
+	f.shifts = make([]shift, len(streams))
 	if err := f.mux.WriteHeader(streams); err != nil {
 		return nil, err
 	}
@@ -58,8 +68,25 @@ func (f *Fragmenter) TimeScale() uint32 {
 	return 90000
 }
 
+// SetCorrection requests that the timestamps of the given stream be shifted by
+// the given amount. The shift is applied gradually by lengthening or shortening
+// each packet by up to 1%.
+func (f *Fragmenter) SetCorrection(idx int, d time.Duration) {
+	f.shifts[idx].goal = d
+}
+
 // WritePacket formats and queues a packet for the next fragment to be written
 func (f *Fragmenter) WritePacket(pkt av.Packet) error {
+	if int(pkt.Idx) < len(f.shifts) {
+		s := &f.shifts[pkt.Idx]
+		if s.started {
+			// stretch or shrink the previous packet to move towards the requested correction
+			s.applied += time.Duration(fragment.CorrectionStep(int64(s.goal-s.applied), int64(pkt.Time-s.last)))
+		}
+		s.last = pkt.Time
+		s.started = true
+		pkt.Time += s.applied
+	}
 	f.pending = append(f.pending, pkt)
 	return nil
 }
@@ -0,0 +1,34 @@
+package tsfrag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+)
+
+func TestCorrection(t *testing.T) {
+	cd := aacparser.CodecData{Config: aacparser.MPEG4AudioConfig{SampleRate: 48000, ChannelLayout: av.CH_STEREO, ObjectType: 2}}
+	f, err := New([]av.CodecData{cd})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const dur = 20 * time.Millisecond
+	f.SetCorrection(0, -time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if err := f.WritePacket(av.Packet{Time: time.Duration(i) * dur}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each packet moves by at most 1% of the previous one's duration, until the goal is reached
+	for i, pkt := range f.pending {
+		shift := -time.Duration(i) * dur / 100
+		if shift < -time.Millisecond {
+			shift = -time.Millisecond
+		}
+		if expected := time.Duration(i)*dur + shift; pkt.Time != expected {
+			t.Errorf("packet %d: expected %s, got %s", i, expected, pkt.Time)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package tsnorm
+
+import (
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+const (
+	// decode times that fail to increase are bumped by this much
+	minStep = time.Millisecond
+	// how long to observe the streams before recording the initial A/V offset
+	warmup = 5 * time.Second
+	// smoothing factor for skew measurements, which are noisy because audio and video are interleaved in bursts
+	skewWeight = 64
+)
+
+// Normalizer rebases packet timestamps so that the stream starts at zero,
+// keeps decode times increasing within each track, and measures how far audio
+// drifts from video over time.
+type Normalizer struct {
+	// MaxDrift is how far audio may drift from video before a correction is requested
+	MaxDrift time.Duration
+
+	tracks  []trackState
+	vidx    int
+	started bool
+	start   time.Duration
+
+	skew       time.Duration // smoothed difference between audio and video timestamps
+	skewSet    bool
+	baseline   time.Duration // skew at the start of the stream
+	baseSet    bool
+	correction time.Duration
+	stats      Stats
+}
+
+type trackState struct {
+	audio bool
+	seen  bool
+	last  time.Duration
+}
+
+// Stats describes the drift between audio and video
+type Stats struct {
+	// Drift is how far audio timestamps currently lead video, relative to where they were at the start of the stream
+	Drift time.Duration
+	// MaxDrift is the largest drift observed in either direction
+	MaxDrift time.Duration
+	// Correction is the shift currently requested for audio timestamps
+	Correction time.Duration
+	// Corrections counts how many times the correction was changed
+	Corrections int
+	// Adjusted counts packets whose timestamps were moved to keep decode times increasing
+	Adjusted int
+}
+
+// New creates a normalizer for the given streams
+func New(streams []av.CodecData, maxDrift time.Duration) *Normalizer {
+	n := &Normalizer{
+		MaxDrift: maxDrift,
+		tracks:   make([]trackState, len(streams)),
+		vidx:     -1,
+	}
+	for i, cd := range streams {
+		if cd.Type().IsVideo() {
+			n.vidx = i
+		} else {
+			n.tracks[i].audio = true
+		}
+	}
+	return n
+}
+
+// Reset starts a new timeline at zero, for example after a discontinuity.
+// The requested correction is also reset.
+func (n *Normalizer) Reset() {
+	for i := range n.tracks {
+		n.tracks[i].seen = false
+	}
+	n.started = false
+	n.skewSet = false
+	n.baseSet = false
+	n.correction = 0
+	n.stats.Drift = 0
+	n.stats.Correction = 0
+}
+
+// Normalize adjusts a packet's timestamps
+func (n *Normalizer) Normalize(pkt *av.Packet) {
+	if int(pkt.Idx) >= len(n.tracks) {
+		return
+	}
+	if !n.started {
+		n.start = pkt.Time
+		n.started = true
+	}
+	pkt.Time -= n.start
+	t := &n.tracks[pkt.Idx]
+	if t.seen && pkt.Time < t.last+minStep {
+		// keep the presentation time where it was
+		shift := t.last + minStep - pkt.Time
+		pkt.Time += shift
+		pkt.CompositionTime -= shift
+		n.stats.Adjusted++
+	} else if !t.seen && pkt.Time < 0 {
+		pkt.CompositionTime += pkt.Time
+		pkt.Time = 0
+	}
+	t.last = pkt.Time
+	t.seen = true
+	if int(pkt.Idx) == n.vidx {
+		n.measure(pkt.Time)
+	}
+}
+
+// compare the latest audio timestamp to a video timestamp
+func (n *Normalizer) measure(vtime time.Duration) {
+	var atime time.Duration
+	var found bool
+	for _, t := range n.tracks {
+		if t.audio && t.seen {
+			atime = t.last
+			found = true
+			break
+		}
+	}
+	if !found {
+		return
+	}
+	sample := atime - vtime
+	if !n.skewSet {
+		n.skew = sample
+		n.skewSet = true
+	} else {
+		n.skew += (sample - n.skew) / skewWeight
+	}
+	if !n.baseSet {
+		if vtime >= warmup {
+			n.baseline = n.skew
+			n.baseSet = true
+		}
+		return
+	}
+	drift := n.skew - n.baseline
+	n.stats.Drift = drift
+	if drift > n.stats.MaxDrift {
+		n.stats.MaxDrift = drift
+	} else if -drift > n.stats.MaxDrift {
+		n.stats.MaxDrift = -drift
+	}
+	if n.MaxDrift <= 0 {
+		return
+	}
+	if remaining := drift + n.correction; remaining > n.MaxDrift || remaining < -n.MaxDrift {
+		// move audio back in line with video
+		n.correction = -drift
+		n.stats.Correction = n.correction
+		n.stats.Corrections++
+	}
+}
+
+// Correction returns the shift that should be applied to audio timestamps to counteract drift
+func (n *Normalizer) Correction() time.Duration {
+	return n.correction
+}
+
+// Stats returns statistics about drift between audio and video
+func (n *Normalizer) Stats() Stats {
+	return n.stats
+}
@@ -0,0 +1,111 @@
+package tsnorm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+type codec av.CodecType
+
+func (c codec) Type() av.CodecType { return av.CodecType(c) }
+
+const (
+	frameDur = time.Second / 30
+	audioDur = 1024 * time.Second / 48000
+)
+
+// interleaved video and audio, with audio timestamps running fast by rate
+type feeder struct {
+	rate   float64
+	vt, at time.Duration
+}
+
+func (f *feeder) feed(n *Normalizer, until time.Duration) {
+	for f.vt < until {
+		var pkt av.Packet
+		if f.at < f.vt {
+			pkt = av.Packet{Idx: 1, Time: time.Duration(float64(f.at) * (1 + f.rate))}
+			f.at += audioDur
+		} else {
+			pkt = av.Packet{Idx: 0, Time: f.vt}
+			f.vt += frameDur
+		}
+		n.Normalize(&pkt)
+	}
+}
+
+func newNormalizer() *Normalizer {
+	return New([]av.CodecData{codec(av.H264), codec(av.AAC)}, 40*time.Millisecond)
+}
+
+func TestNoDrift(t *testing.T) {
+	n := newNormalizer()
+	f := &feeder{}
+	f.feed(n, time.Minute)
+	if c := n.Correction(); c != 0 {
+		t.Errorf("expected no correction, got %s", c)
+	}
+	if st := n.Stats(); st.Corrections != 0 || st.MaxDrift > 10*time.Millisecond {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestDrift(t *testing.T) {
+	n := newNormalizer()
+	// audio gains 2ms per second, so crosses the 40ms limit about 20s after warmup
+	f := &feeder{rate: 0.002}
+	f.feed(n, 20*time.Second)
+	if c := n.Correction(); c != 0 {
+		t.Errorf("expected no correction before the limit is reached, got %s", c)
+	}
+	f.feed(n, 40*time.Second)
+	c := n.Correction()
+	if c >= -40*time.Millisecond || c < -60*time.Millisecond {
+		t.Errorf("expected audio to be moved back by about 40ms, got %s", c)
+	}
+	st := n.Stats()
+	if st.Corrections != 1 || st.Correction != c {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if remaining := st.Drift + c; remaining > n.MaxDrift || remaining < -n.MaxDrift {
+		t.Errorf("drift %s is not within the limit after correcting by %s", st.Drift, c)
+	}
+	n.Reset()
+	if c := n.Correction(); c != 0 {
+		t.Errorf("expected reset to clear the correction, got %s", c)
+	}
+}
+
+func TestDriftDisabled(t *testing.T) {
+	n := newNormalizer()
+	n.MaxDrift = -1
+	f := &feeder{rate: 0.002}
+	f.feed(n, 40*time.Second)
+	if c := n.Correction(); c != 0 {
+		t.Errorf("expected no correction, got %s", c)
+	}
+	if st := n.Stats(); st.MaxDrift < 40*time.Millisecond {
+		t.Errorf("expected drift to be measured, got %+v", st)
+	}
+}
+
+func TestMonotonic(t *testing.T) {
+	n := newNormalizer()
+	times := []time.Duration{10 * time.Second, 10*time.Second + frameDur, 10*time.Second + frameDur, 10 * time.Second}
+	expected := []time.Duration{0, frameDur, frameDur + minStep, frameDur + 2*minStep}
+	for i, ts := range times {
+		pkt := av.Packet{Idx: 0, Time: ts}
+		n.Normalize(&pkt)
+		if pkt.Time != expected[i] {
+			t.Errorf("packet %d: expected %s, got %s", i, expected[i], pkt.Time)
+		}
+		if pts := pkt.Time + pkt.CompositionTime; pts != ts-10*time.Second {
+			t.Errorf("packet %d: presentation time moved to %s", i, pts)
+		}
+	}
+	if st := n.Stats(); st.Adjusted != 2 {
+		t.Errorf("expected 2 adjusted packets, got %d", st.Adjusted)
+	}
+}
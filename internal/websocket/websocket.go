@@ -0,0 +1,216 @@
+// Package websocket implements the server side of RFC 6455, enough to push messages to a client
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opText   = 0x1
+	opBinary = 0x2
+	opClose  = 0x8
+	opPing   = 0x9
+	opPong   = 0xa
+
+	acceptGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	writeTimeout   = 10 * time.Second
+	maxReadMessage = 1 << 16
+)
+
+// Conn is a server-side WebSocket connection
+type Conn struct {
+	c    net.Conn
+	r    *bufio.Reader
+	mu   sync.Mutex // serializes writes
+	done chan struct{}
+	once sync.Once
+}
+
+// Upgrade completes the WebSocket handshake and takes over the connection. If
+// it fails then an error response has already been sent.
+func Upgrade(rw http.ResponseWriter, req *http.Request) (*Conn, error) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case req.Method != http.MethodGet:
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, errors.New("websocket: method not allowed")
+	case !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket"):
+		http.Error(rw, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("websocket: not an upgrade request")
+	case req.Header.Get("Sec-WebSocket-Version") != "13":
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "unsupported websocket version", http.StatusBadRequest)
+		return nil, errors.New("websocket: unsupported version")
+	case key == "":
+		http.Error(rw, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("websocket: missing key")
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "websocket not supported", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response can't be hijacked")
+	}
+	c, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	d := sha1.Sum([]byte(key + acceptGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	brw.WriteString(base64.StdEncoding.EncodeToString(d[:]))
+	brw.WriteString("\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	conn := &Conn{
+		c:    c,
+		r:    brw.Reader,
+		done: make(chan struct{}),
+	}
+	go conn.readLoop()
+	return conn, nil
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Done is closed when the client disconnects
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// WriteBinary sends a binary message
+func (c *Conn) WriteBinary(d []byte) error {
+	return c.writeFrame(opBinary, d)
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(d []byte) error {
+	return c.writeFrame(opText, d)
+}
+
+// Close sends a close message and closes the connection
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, []byte{0x03, 0xe8}) // 1000 normal closure
+	c.shutdown()
+	return nil
+}
+
+func (c *Conn) shutdown() {
+	c.once.Do(func() {
+		close(c.done)
+		c.c.Close()
+	})
+}
+
+// send a single unfragmented frame. server frames are not masked.
+func (c *Conn) writeFrame(op byte, d []byte) error {
+	var hdr [10]byte
+	hdr[0] = 0x80 | op
+	n := 2
+	switch {
+	case len(d) < 126:
+		hdr[1] = byte(len(d))
+	case len(d) <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(len(d)))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(len(d)))
+		n = 10
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.c.Write(hdr[:n]); err != nil {
+		c.shutdown()
+		return err
+	}
+	if _, err := c.c.Write(d); err != nil {
+		c.shutdown()
+		return err
+	}
+	return nil
+}
+
+// read frames from the client, answering pings and watching for close
+func (c *Conn) readLoop() {
+	defer c.shutdown()
+	for {
+		op, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch op {
+		case opPing:
+			if c.writeFrame(opPong, payload) != nil {
+				return
+			}
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return
+		}
+		// data messages from the client are ignored
+	}
+}
+
+func (c *Conn) readFrame() (op byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.r, hdr[:]); err != nil {
+		return
+	}
+	op = hdr[0] & 0x0f
+	if hdr[1]&0x80 == 0 {
+		// client frames must be masked
+		return 0, nil, errors.New("websocket: unmasked client frame")
+	}
+	length := uint64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.r, ext[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxReadMessage {
+		return 0, nil, errors.New("websocket: client message too large")
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.r, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
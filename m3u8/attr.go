@@ -0,0 +1,100 @@
+package m3u8
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attribute list of a tag, with quotes removed from quoted strings
+type attrList map[string]string
+
+func parseAttrs(l line) (attrList, error) {
+	attrs := make(attrList)
+	s := l.value
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, l.errorf("malformed attribute list")
+		}
+		name := s[:eq]
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return nil, l.errorf("unterminated string in %s", name)
+			}
+			value = s[1 : end+1]
+			s = s[end+2:]
+			if s != "" && s[0] != ',' {
+				return nil, l.errorf("malformed attribute list")
+			}
+			s = strings.TrimPrefix(s, ",")
+		} else {
+			value, s, _ = strings.Cut(s, ",")
+		}
+		attrs[name] = value
+	}
+	return attrs, nil
+}
+
+func (a attrList) int(l line, name string) (int, error) {
+	v, err := a.int64(l, name)
+	return int(v), err
+}
+
+func (a attrList) int64(l line, name string) (int64, error) {
+	v, ok := a[name]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, l.errorf("invalid %s", name)
+	}
+	return n, nil
+}
+
+func (a attrList) float(l line, name string) (float64, error) {
+	v, ok := a[name]
+	if !ok {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, l.errorf("invalid %s", name)
+	}
+	return f, nil
+}
+
+func (a attrList) dur(l line, name string) (time.Duration, error) {
+	v, ok := a[name]
+	if !ok {
+		return 0, nil
+	}
+	d, err := parseSeconds(v)
+	if err != nil {
+		return 0, l.errorf("invalid %s", name)
+	}
+	return d, nil
+}
+
+// parse a WIDTHxHEIGHT pair
+func (a attrList) resolution(l line, name string) (width, height int, err error) {
+	v, ok := a[name]
+	if !ok {
+		return 0, 0, nil
+	}
+	w, h, ok := strings.Cut(v, "x")
+	if !ok {
+		return 0, 0, l.errorf("invalid %s", name)
+	}
+	if width, err = strconv.Atoi(w); err != nil {
+		return 0, 0, l.errorf("invalid %s", name)
+	}
+	if height, err = strconv.Atoi(h); err != nil {
+		return 0, 0, l.errorf("invalid %s", name)
+	}
+	return width, height, nil
+}
@@ -0,0 +1,359 @@
+package m3u8
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotPlaylist is returned when the input doesn't begin with #EXTM3U
+var ErrNotPlaylist = errors.New("not an m3u8 playlist")
+
+// Parse decodes a master or media playlist, depending on which tags it contains
+func Parse(data []byte) (Playlist, error) {
+	if isMaster(data) {
+		p := new(MasterPlaylist)
+		if err := p.UnmarshalText(data); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	p := new(MediaPlaylist)
+	if err := p.UnmarshalText(data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func isMaster(data []byte) bool {
+	for _, tag := range []string{"#EXT-X-STREAM-INF:", "#EXT-X-MEDIA:", "#EXT-X-IMAGE-STREAM-INF:"} {
+		if bytes.Contains(data, []byte(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// a line of the playlist, split into the tag and its value
+type line struct {
+	num   int
+	tag   string // empty for URIs
+	value string
+}
+
+func (l line) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s: %s", l.num, l.tag, fmt.Sprintf(format, args...))
+}
+
+// split a playlist into tags and URIs, skipping comments and blank lines
+func readLines(data []byte) ([]line, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	var lines []line
+	var num int
+	for scanner.Scan() {
+		num++
+		text := strings.TrimSpace(scanner.Text())
+		if num == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+			if text != "#EXTM3U" {
+				return nil, ErrNotPlaylist
+			}
+			continue
+		}
+		switch {
+		case text == "":
+		case strings.HasPrefix(text, "#EXT"):
+			tag, value, _ := strings.Cut(text, ":")
+			lines = append(lines, line{num: num, tag: tag, value: value})
+		case text[0] == '#':
+			// comment
+		default:
+			lines = append(lines, line{num: num, value: text})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if num == 0 {
+		return nil, ErrNotPlaylist
+	}
+	return lines, nil
+}
+
+// UnmarshalText decodes a master playlist
+func (p *MasterPlaylist) UnmarshalText(data []byte) error {
+	lines, err := readLines(data)
+	if err != nil {
+		return err
+	}
+	*p = MasterPlaylist{}
+	var variant *Variant
+	for _, l := range lines {
+		if l.tag == "" {
+			if variant == nil {
+				return fmt.Errorf("line %d: URI without EXT-X-STREAM-INF", l.num)
+			}
+			variant.URI = l.value
+			p.Variants = append(p.Variants, *variant)
+			variant = nil
+			continue
+		}
+		switch l.tag {
+		case "#EXT-X-VERSION":
+			if p.Version, err = strconv.Atoi(l.value); err != nil {
+				return l.errorf("invalid version")
+			}
+		case "#EXT-X-INDEPENDENT-SEGMENTS":
+			p.IndependentSegments = true
+		case "#EXT-X-MEDIA":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			p.Renditions = append(p.Renditions, Rendition{
+				Type:       attrs["TYPE"],
+				GroupID:    attrs["GROUP-ID"],
+				Name:       attrs["NAME"],
+				Language:   attrs["LANGUAGE"],
+				Default:    attrs["DEFAULT"] == "YES",
+				Autoselect: attrs["AUTOSELECT"] == "YES",
+				Channels:   attrs["CHANNELS"],
+				InstreamID: attrs["INSTREAM-ID"],
+				URI:        attrs["URI"],
+			})
+		case "#EXT-X-IMAGE-STREAM-INF":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			s := ImageStream{Codecs: attrs["CODECS"], URI: attrs["URI"]}
+			if s.Bandwidth, err = attrs.int(l, "BANDWIDTH"); err != nil {
+				return err
+			}
+			if s.Width, s.Height, err = attrs.resolution(l, "RESOLUTION"); err != nil {
+				return err
+			}
+			p.ImageStreams = append(p.ImageStreams, s)
+		case "#EXT-X-STREAM-INF":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			variant = &Variant{
+				Audio:          attrs["AUDIO"],
+				Video:          attrs["VIDEO"],
+				Subtitles:      attrs["SUBTITLES"],
+				ClosedCaptions: attrs["CLOSED-CAPTIONS"],
+				Codecs:         attrs["CODECS"],
+			}
+			if variant.Bandwidth, err = attrs.int(l, "BANDWIDTH"); err != nil {
+				return err
+			}
+			if variant.AverageBandwidth, err = attrs.int(l, "AVERAGE-BANDWIDTH"); err != nil {
+				return err
+			}
+			if variant.Width, variant.Height, err = attrs.resolution(l, "RESOLUTION"); err != nil {
+				return err
+			}
+			if variant.FrameRate, err = attrs.float(l, "FRAME-RATE"); err != nil {
+				return err
+			}
+		}
+	}
+	if variant != nil {
+		return errors.New("EXT-X-STREAM-INF without a URI")
+	}
+	return nil
+}
+
+// UnmarshalText decodes a media playlist
+func (p *MediaPlaylist) UnmarshalText(data []byte) error {
+	lines, err := readLines(data)
+	if err != nil {
+		return err
+	}
+	*p = MediaPlaylist{}
+	var seg Segment
+	var pending bool // seg has tags applying to it
+	for _, l := range lines {
+		if l.tag == "" {
+			seg.URI = l.value
+			p.Segments = append(p.Segments, seg)
+			seg, pending = Segment{}, false
+			continue
+		}
+		switch l.tag {
+		case "#EXT-X-VERSION":
+			if p.Version, err = strconv.Atoi(l.value); err != nil {
+				return l.errorf("invalid version")
+			}
+		case "#EXT-X-TARGETDURATION":
+			if p.TargetDuration, err = strconv.Atoi(l.value); err != nil {
+				return l.errorf("invalid target duration")
+			}
+		case "#EXT-X-MEDIA-SEQUENCE":
+			if p.MediaSequence, err = strconv.ParseInt(l.value, 10, 64); err != nil {
+				return l.errorf("invalid sequence number")
+			}
+		case "#EXT-X-DISCONTINUITY-SEQUENCE":
+			if p.DiscontinuitySequence, err = strconv.ParseInt(l.value, 10, 64); err != nil {
+				return l.errorf("invalid sequence number")
+			}
+		case "#EXT-X-PLAYLIST-TYPE":
+			p.PlaylistType = l.value
+		case "#EXT-X-INDEPENDENT-SEGMENTS":
+			p.IndependentSegments = true
+		case "#EXT-X-IMAGES-ONLY":
+			p.ImagesOnly = true
+		case "#EXT-X-ENDLIST":
+			p.EndList = true
+		case "#EXT-X-SERVER-CONTROL":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			sc := &ServerControl{
+				CanSkipDateRanges: attrs["CAN-SKIP-DATERANGES"] == "YES",
+				CanBlockReload:    attrs["CAN-BLOCK-RELOAD"] == "YES",
+			}
+			if sc.CanSkipUntil, err = attrs.dur(l, "CAN-SKIP-UNTIL"); err != nil {
+				return err
+			}
+			if sc.HoldBack, err = attrs.dur(l, "HOLD-BACK"); err != nil {
+				return err
+			}
+			if sc.PartHoldBack, err = attrs.dur(l, "PART-HOLD-BACK"); err != nil {
+				return err
+			}
+			p.ServerControl = sc
+		case "#EXT-X-PART-INF":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			if p.PartTarget, err = attrs.dur(l, "PART-TARGET"); err != nil {
+				return err
+			}
+		case "#EXT-X-SKIP":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			skip := new(Skip)
+			if skip.SkippedSegments, err = attrs.int(l, "SKIPPED-SEGMENTS"); err != nil {
+				return err
+			}
+			p.Skip = skip
+		case "#EXTINF":
+			dur, title, _ := strings.Cut(l.value, ",")
+			if seg.Duration, err = parseSeconds(dur); err != nil {
+				return l.errorf("invalid duration")
+			}
+			seg.Title = title
+			pending = true
+		case "#EXT-X-PROGRAM-DATE-TIME":
+			if seg.ProgramDateTime, err = time.Parse(time.RFC3339Nano, l.value); err != nil {
+				return l.errorf("invalid date")
+			}
+			pending = true
+		case "#EXT-X-DISCONTINUITY":
+			seg.Discontinuity = true
+			pending = true
+		case "#EXT-X-GAP":
+			seg.Gap = true
+			pending = true
+		case "#EXT-X-BITRATE":
+			if seg.Bitrate, err = strconv.Atoi(l.value); err != nil {
+				return l.errorf("invalid bitrate")
+			}
+			pending = true
+		case "#EXT-X-MAP":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			seg.Map = &Map{URI: attrs["URI"], ByteRange: attrs["BYTERANGE"]}
+			pending = true
+		case "#EXT-X-TILES":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			tiles := new(Tiles)
+			if tiles.Width, tiles.Height, err = attrs.resolution(l, "RESOLUTION"); err != nil {
+				return err
+			}
+			if tiles.Columns, tiles.Rows, err = attrs.resolution(l, "LAYOUT"); err != nil {
+				return err
+			}
+			if tiles.Duration, err = attrs.dur(l, "DURATION"); err != nil {
+				return err
+			}
+			seg.Tiles = tiles
+			pending = true
+		case "#EXT-X-PART":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			part := Part{
+				URI:         attrs["URI"],
+				Independent: attrs["INDEPENDENT"] == "YES",
+				ByteRange:   attrs["BYTERANGE"],
+				Gap:         attrs["GAP"] == "YES",
+			}
+			if part.Duration, err = attrs.dur(l, "DURATION"); err != nil {
+				return err
+			}
+			seg.Parts = append(seg.Parts, part)
+			pending = true
+		case "#EXT-X-PRELOAD-HINT":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			h := PreloadHint{Type: attrs["TYPE"], URI: attrs["URI"]}
+			if h.ByteRangeStart, err = attrs.int64(l, "BYTERANGE-START"); err != nil {
+				return err
+			}
+			if h.ByteRangeLength, err = attrs.int64(l, "BYTERANGE-LENGTH"); err != nil {
+				return err
+			}
+			p.PreloadHints = append(p.PreloadHints, h)
+		case "#EXT-X-RENDITION-REPORT":
+			attrs, err := parseAttrs(l)
+			if err != nil {
+				return err
+			}
+			r := RenditionReport{URI: attrs["URI"], LastPart: -1}
+			if r.LastMSN, err = attrs.int64(l, "LAST-MSN"); err != nil {
+				return err
+			}
+			if _, ok := attrs["LAST-PART"]; ok {
+				if r.LastPart, err = attrs.int(l, "LAST-PART"); err != nil {
+					return err
+				}
+			}
+			p.RenditionReports = append(p.RenditionReports, r)
+		}
+	}
+	if pending {
+		// segment in progress
+		p.Segments = append(p.Segments, seg)
+	}
+	return nil
+}
+
+func parseSeconds(v string) (time.Duration, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) {
+		return 0, errors.New("invalid duration")
+	}
+	return time.Duration(math.Round(f * float64(time.Second))), nil
+}
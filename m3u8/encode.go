@@ -0,0 +1,282 @@
+package m3u8
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"time"
+)
+
+const programTimeFormat = "2006-01-02T15:04:05.999Z07:00"
+
+// writes a tag with its attribute list
+type tagWriter struct {
+	b     *bytes.Buffer
+	attrs int
+}
+
+func startTag(b *bytes.Buffer, tag string) *tagWriter {
+	b.WriteString(tag)
+	b.WriteByte(':')
+	return &tagWriter{b: b}
+}
+
+func (t *tagWriter) name(name string) {
+	if t.attrs != 0 {
+		t.b.WriteByte(',')
+	}
+	t.attrs++
+	t.b.WriteString(name)
+	t.b.WriteByte('=')
+}
+
+func (t *tagWriter) str(name, v string) {
+	if v == "" {
+		return
+	}
+	t.name(name)
+	t.b.WriteByte('"')
+	t.b.WriteString(v)
+	t.b.WriteByte('"')
+}
+
+func (t *tagWriter) enum(name, v string) {
+	if v == "" {
+		return
+	}
+	t.name(name)
+	t.b.WriteString(v)
+}
+
+func (t *tagWriter) yes(name string, v bool) {
+	if v {
+		t.enum(name, "YES")
+	}
+}
+
+func (t *tagWriter) int(name string, v int64) {
+	t.name(name)
+	t.b.WriteString(strconv.FormatInt(v, 10))
+}
+
+func (t *tagWriter) dur(name string, v time.Duration) {
+	t.name(name)
+	t.b.WriteString(formatSeconds(v))
+}
+
+func (t *tagWriter) resolution(name string, width, height int) {
+	if width == 0 && height == 0 {
+		return
+	}
+	t.name(name)
+	t.b.WriteString(strconv.Itoa(width))
+	t.b.WriteByte('x')
+	t.b.WriteString(strconv.Itoa(height))
+}
+
+func (t *tagWriter) end() {
+	t.b.WriteByte('\n')
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
+
+func writeInt(b *bytes.Buffer, tag string, v int64) {
+	b.WriteString(tag)
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatInt(v, 10))
+	b.WriteByte('\n')
+}
+
+// MarshalText formats the playlist
+func (p *MasterPlaylist) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	if p.Version != 0 {
+		writeInt(&b, "#EXT-X-VERSION", int64(p.Version))
+	}
+	if p.IndependentSegments {
+		b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	for _, r := range p.Renditions {
+		t := startTag(&b, "#EXT-X-MEDIA")
+		t.enum("TYPE", r.Type)
+		t.str("GROUP-ID", r.GroupID)
+		t.str("NAME", r.Name)
+		t.str("LANGUAGE", r.Language)
+		t.yes("DEFAULT", r.Default)
+		t.yes("AUTOSELECT", r.Autoselect)
+		t.str("CHANNELS", r.Channels)
+		t.str("INSTREAM-ID", r.InstreamID)
+		t.str("URI", r.URI)
+		t.end()
+	}
+	for _, s := range p.ImageStreams {
+		t := startTag(&b, "#EXT-X-IMAGE-STREAM-INF")
+		t.int("BANDWIDTH", int64(s.Bandwidth))
+		t.resolution("RESOLUTION", s.Width, s.Height)
+		t.str("CODECS", s.Codecs)
+		t.str("URI", s.URI)
+		t.end()
+	}
+	for _, v := range p.Variants {
+		t := startTag(&b, "#EXT-X-STREAM-INF")
+		t.int("BANDWIDTH", int64(v.Bandwidth))
+		if v.AverageBandwidth != 0 {
+			t.int("AVERAGE-BANDWIDTH", int64(v.AverageBandwidth))
+		}
+		t.str("AUDIO", v.Audio)
+		t.str("VIDEO", v.Video)
+		t.str("SUBTITLES", v.Subtitles)
+		t.str("CLOSED-CAPTIONS", v.ClosedCaptions)
+		t.str("CODECS", v.Codecs)
+		t.resolution("RESOLUTION", v.Width, v.Height)
+		if v.FrameRate != 0 {
+			t.enum("FRAME-RATE", strconv.FormatFloat(v.FrameRate, 'f', 3, 64))
+		}
+		t.end()
+		b.WriteString(v.URI)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+// WriteTo writes the formatted playlist to w
+func (p *MasterPlaylist) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
+}
+
+// MarshalText formats the playlist
+func (p *MediaPlaylist) MarshalText() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	if p.Version != 0 {
+		writeInt(&b, "#EXT-X-VERSION", int64(p.Version))
+	}
+	writeInt(&b, "#EXT-X-TARGETDURATION", int64(p.TargetDuration))
+	writeInt(&b, "#EXT-X-MEDIA-SEQUENCE", p.MediaSequence)
+	if p.DiscontinuitySequence != 0 {
+		writeInt(&b, "#EXT-X-DISCONTINUITY-SEQUENCE", p.DiscontinuitySequence)
+	}
+	if p.PlaylistType != "" {
+		b.WriteString("#EXT-X-PLAYLIST-TYPE:" + p.PlaylistType + "\n")
+	}
+	if p.IndependentSegments {
+		b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	}
+	if p.ImagesOnly {
+		b.WriteString("#EXT-X-IMAGES-ONLY\n")
+	}
+	if sc := p.ServerControl; sc != nil {
+		t := startTag(&b, "#EXT-X-SERVER-CONTROL")
+		if sc.CanSkipUntil != 0 {
+			t.dur("CAN-SKIP-UNTIL", sc.CanSkipUntil)
+			t.yes("CAN-SKIP-DATERANGES", sc.CanSkipDateRanges)
+		}
+		if sc.HoldBack != 0 {
+			t.dur("HOLD-BACK", sc.HoldBack)
+		}
+		if sc.PartHoldBack != 0 {
+			t.dur("PART-HOLD-BACK", sc.PartHoldBack)
+		}
+		t.yes("CAN-BLOCK-RELOAD", sc.CanBlockReload)
+		t.end()
+	}
+	if p.PartTarget != 0 {
+		t := startTag(&b, "#EXT-X-PART-INF")
+		t.dur("PART-TARGET", p.PartTarget)
+		t.end()
+	}
+	if p.Skip != nil {
+		t := startTag(&b, "#EXT-X-SKIP")
+		t.int("SKIPPED-SEGMENTS", int64(p.Skip.SkippedSegments))
+		t.end()
+	}
+	for i := range p.Segments {
+		p.Segments[i].format(&b)
+	}
+	for _, h := range p.PreloadHints {
+		t := startTag(&b, "#EXT-X-PRELOAD-HINT")
+		t.enum("TYPE", h.Type)
+		t.str("URI", h.URI)
+		if h.ByteRangeStart != 0 {
+			t.int("BYTERANGE-START", h.ByteRangeStart)
+		}
+		if h.ByteRangeLength != 0 {
+			t.int("BYTERANGE-LENGTH", h.ByteRangeLength)
+		}
+		t.end()
+	}
+	for _, r := range p.RenditionReports {
+		t := startTag(&b, "#EXT-X-RENDITION-REPORT")
+		t.str("URI", r.URI)
+		t.int("LAST-MSN", r.LastMSN)
+		if r.LastPart >= 0 {
+			t.int("LAST-PART", int64(r.LastPart))
+		}
+		t.end()
+	}
+	if p.EndList {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.Bytes(), nil
+}
+
+// WriteTo writes the formatted playlist to w
+func (p *MediaPlaylist) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
+}
+
+func writeTo(w io.Writer, p Playlist) (int64, error) {
+	blob, err := p.MarshalText()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(blob)
+	return int64(n), err
+}
+
+func (s *Segment) format(b *bytes.Buffer) {
+	if !s.ProgramDateTime.IsZero() {
+		b.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + s.ProgramDateTime.Format(programTimeFormat) + "\n")
+	}
+	if s.Discontinuity {
+		b.WriteString("#EXT-X-DISCONTINUITY\n")
+	}
+	if s.Map != nil {
+		t := startTag(b, "#EXT-X-MAP")
+		t.str("URI", s.Map.URI)
+		t.str("BYTERANGE", s.Map.ByteRange)
+		t.end()
+	}
+	if s.Gap {
+		b.WriteString("#EXT-X-GAP\n")
+	}
+	if s.Bitrate != 0 {
+		writeInt(b, "#EXT-X-BITRATE", int64(s.Bitrate))
+	}
+	for _, part := range s.Parts {
+		t := startTag(b, "#EXT-X-PART")
+		t.dur("DURATION", part.Duration)
+		t.yes("INDEPENDENT", part.Independent)
+		t.str("URI", part.URI)
+		t.str("BYTERANGE", part.ByteRange)
+		t.yes("GAP", part.Gap)
+		t.end()
+	}
+	if s.URI == "" {
+		// still in progress
+		return
+	}
+	if s.Tiles != nil {
+		t := startTag(b, "#EXT-X-TILES")
+		t.resolution("RESOLUTION", s.Tiles.Width, s.Tiles.Height)
+		t.resolution("LAYOUT", s.Tiles.Columns, s.Tiles.Rows)
+		t.dur("DURATION", s.Tiles.Duration)
+		t.end()
+	}
+	b.WriteString("#EXTINF:" + formatSeconds(s.Duration) + "," + s.Title + "\n")
+	b.WriteString(s.URI)
+	b.WriteByte('\n')
+}
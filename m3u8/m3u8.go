@@ -0,0 +1,153 @@
+// Package m3u8 reads and writes HLS playlists, including the tags used by
+// Low-Latency HLS.
+//
+// Durations are time.Durations and are written with microsecond precision.
+// Tags that the package doesn't know are skipped when decoding.
+package m3u8
+
+import (
+	"io"
+	"time"
+)
+
+// Playlist is a *MasterPlaylist or a *MediaPlaylist
+type Playlist interface {
+	MarshalText() ([]byte, error)
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// MasterPlaylist lists the variant streams and renditions of a presentation
+type MasterPlaylist struct {
+	Version             int
+	IndependentSegments bool
+
+	Renditions   []Rendition
+	ImageStreams []ImageStream
+	Variants     []Variant
+}
+
+// Rendition is an alternative rendition from an EXT-X-MEDIA tag
+type Rendition struct {
+	Type       string // AUDIO, VIDEO, SUBTITLES or CLOSED-CAPTIONS
+	GroupID    string
+	Name       string
+	Language   string
+	Default    bool
+	Autoselect bool
+	Channels   string
+	InstreamID string
+	URI        string
+}
+
+// Variant is a variant stream from an EXT-X-STREAM-INF tag and the URI following it
+type Variant struct {
+	Bandwidth        int
+	AverageBandwidth int
+	Audio            string
+	Video            string
+	Subtitles        string
+	ClosedCaptions   string
+	Codecs           string
+	Width, Height    int
+	FrameRate        float64
+	URI              string
+}
+
+// ImageStream is a stream of thumbnail tiles from an EXT-X-IMAGE-STREAM-INF tag
+type ImageStream struct {
+	Bandwidth     int
+	Width, Height int
+	Codecs        string
+	URI           string
+}
+
+// MediaPlaylist lists the segments of a single rendition
+type MediaPlaylist struct {
+	Version int
+	// TargetDuration is the maximum segment duration in whole seconds
+	TargetDuration        int
+	MediaSequence         int64
+	DiscontinuitySequence int64
+	PlaylistType          string // EVENT or VOD
+	IndependentSegments   bool
+	ImagesOnly            bool
+	ServerControl         *ServerControl
+	PartTarget            time.Duration
+	Skip                  *Skip
+
+	// Segments in order. The last one has no URI if it is still in progress
+	// and only its parts are listed.
+	Segments         []Segment
+	PreloadHints     []PreloadHint
+	RenditionReports []RenditionReport
+	EndList          bool
+}
+
+// ServerControl describes the delivery directives supported by the server
+type ServerControl struct {
+	CanSkipUntil      time.Duration
+	CanSkipDateRanges bool
+	HoldBack          time.Duration
+	PartHoldBack      time.Duration
+	CanBlockReload    bool
+}
+
+// Skip replaces the segments removed from a playlist delta update
+type Skip struct {
+	SkippedSegments int
+}
+
+// Segment is a media segment from an EXTINF tag and the tags preceding it
+type Segment struct {
+	URI      string
+	Duration time.Duration
+	Title    string
+
+	ProgramDateTime time.Time
+	Discontinuity   bool
+	// Map is set on the segment preceded by an EXT-X-MAP tag. It also applies
+	// to the segments after it.
+	Map     *Map
+	Gap     bool
+	Bitrate int
+	Tiles   *Tiles
+	Parts   []Part
+}
+
+// Map refers to the initialization section needed to parse the segments that follow
+type Map struct {
+	URI       string
+	ByteRange string
+}
+
+// Tiles describes the layout of an image segment in an image playlist
+type Tiles struct {
+	Width, Height int
+	Columns, Rows int
+	Duration      time.Duration
+}
+
+// Part is a partial segment from an EXT-X-PART tag
+type Part struct {
+	URI         string
+	Duration    time.Duration
+	Independent bool
+	ByteRange   string
+	Gap         bool
+}
+
+// PreloadHint announces a resource that will be available soon
+type PreloadHint struct {
+	Type string // PART or MAP
+	URI  string
+	// ByteRangeStart and ByteRangeLength are omitted if zero
+	ByteRangeStart, ByteRangeLength int64
+}
+
+// RenditionReport gives the latest segment and part of another rendition
+type RenditionReport struct {
+	URI     string
+	LastMSN int64
+	// LastPart is omitted if negative
+	LastPart int
+}
@@ -0,0 +1,179 @@
+package m3u8
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const lowLatencyPlaylist = `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:4
+#EXT-X-MEDIA-SEQUENCE:266
+#EXT-X-DISCONTINUITY-SEQUENCE:2
+#EXT-X-SERVER-CONTROL:CAN-SKIP-UNTIL=24.000000,HOLD-BACK=6.000000,PART-HOLD-BACK=1.002000,CAN-BLOCK-RELOAD=YES
+#EXT-X-PART-INF:PART-TARGET=0.334000
+#EXT-X-SKIP:SKIPPED-SEGMENTS=3
+#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:00.5Z
+#EXT-X-MAP:URI="init.mp4"
+#EXTINF:4.000080,
+fileSequence269.mp4
+#EXT-X-DISCONTINUITY
+#EXT-X-PART:DURATION=0.334000,INDEPENDENT=YES,URI="filePart270.0.mp4"
+#EXT-X-PART:DURATION=0.334000,URI="filePart270.1.mp4",BYTERANGE="1000@2000"
+#EXTINF:0.668000,title, with comma
+fileSequence270.mp4
+# a comment
+#EXT-X-PART:DURATION=0.334000,INDEPENDENT=YES,URI="filePart271.0.mp4"
+#EXT-X-PART:DURATION=0.334000,URI="filePart271.1.mp4",GAP=YES
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="filePart271.2.mp4"
+#EXT-X-RENDITION-REPORT:URI="../1M/waitForMSN.php",LAST-MSN=271,LAST-PART=1
+#EXT-X-RENDITION-REPORT:URI="../4M/waitForMSN.php",LAST-MSN=270
+`
+
+func TestDecodeMedia(t *testing.T) {
+	pl, err := Parse([]byte(lowLatencyPlaylist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := pl.(*MediaPlaylist)
+	if !ok {
+		t.Fatalf("expected a media playlist, got %T", pl)
+	}
+	part := 334 * time.Millisecond
+	expected := &MediaPlaylist{
+		Version:               9,
+		TargetDuration:        4,
+		MediaSequence:         266,
+		DiscontinuitySequence: 2,
+		ServerControl: &ServerControl{
+			CanSkipUntil:   24 * time.Second,
+			HoldBack:       6 * time.Second,
+			PartHoldBack:   1002 * time.Millisecond,
+			CanBlockReload: true,
+		},
+		PartTarget: part,
+		Skip:       &Skip{SkippedSegments: 3},
+		Segments: []Segment{
+			{
+				URI:             "fileSequence269.mp4",
+				Duration:        4000080 * time.Microsecond,
+				ProgramDateTime: time.Date(2026, 1, 1, 0, 0, 0, 500000000, time.UTC),
+				Map:             &Map{URI: "init.mp4"},
+			},
+			{
+				URI:           "fileSequence270.mp4",
+				Duration:      668 * time.Millisecond,
+				Title:         "title, with comma",
+				Discontinuity: true,
+				Parts: []Part{
+					{URI: "filePart270.0.mp4", Duration: part, Independent: true},
+					{URI: "filePart270.1.mp4", Duration: part, ByteRange: "1000@2000"},
+				},
+			},
+			{
+				Parts: []Part{
+					{URI: "filePart271.0.mp4", Duration: part, Independent: true},
+					{URI: "filePart271.1.mp4", Duration: part, Gap: true},
+				},
+			},
+		},
+		PreloadHints: []PreloadHint{{Type: "PART", URI: "filePart271.2.mp4"}},
+		RenditionReports: []RenditionReport{
+			{URI: "../1M/waitForMSN.php", LastMSN: 271, LastPart: 1},
+			{URI: "../4M/waitForMSN.php", LastMSN: 270, LastPart: -1},
+		},
+	}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, p)
+	}
+	// encoding and decoding again gives the same playlist
+	blob, err := p.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p2 MediaPlaylist
+	if err := p2.UnmarshalText(blob); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&p2, expected) {
+		t.Errorf("round trip changed the playlist:\n%s", blob)
+	}
+}
+
+func TestEncodeMedia(t *testing.T) {
+	p := &MediaPlaylist{
+		Version:        7,
+		TargetDuration: 12,
+		ImagesOnly:     true,
+		Segments: []Segment{{
+			URI:      "tile0.jpg",
+			Duration: 12 * time.Second,
+			Tiles:    &Tiles{Width: 160, Height: 90, Columns: 2, Rows: 2, Duration: 3 * time.Second},
+		}},
+		EndList: true,
+	}
+	blob, err := p.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:12
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-IMAGES-ONLY
+#EXT-X-TILES:RESOLUTION=160x90,LAYOUT=2x2,DURATION=3.000000
+#EXTINF:12.000000,
+tile0.jpg
+#EXT-X-ENDLIST
+`
+	if string(blob) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, blob)
+	}
+}
+
+func TestMaster(t *testing.T) {
+	expected := `#EXTM3U
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="English",LANGUAGE="en",DEFAULT=YES,AUTOSELECT=YES,CHANNELS="2",URI="audio.m3u8"
+#EXT-X-IMAGE-STREAM-INF:BANDWIDTH=17,RESOLUTION=320x180,CODECS="jpeg",URI="images.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,AVERAGE-BANDWIDTH=5000000,AUDIO="audio",CODECS="avc1.640028,mp4a.40.2",RESOLUTION=1920x1080,FRAME-RATE=29.970
+video.m3u8
+`
+	pl, err := Parse([]byte(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := pl.(*MasterPlaylist)
+	if !ok {
+		t.Fatalf("expected a master playlist, got %T", pl)
+	}
+	if len(p.Variants) != 1 || p.Variants[0].Width != 1920 || p.Variants[0].FrameRate != 29.97 || p.Variants[0].URI != "video.m3u8" {
+		t.Errorf("unexpected variants %+v", p.Variants)
+	}
+	if len(p.Renditions) != 1 || !p.Renditions[0].Default || p.Renditions[0].Language != "en" {
+		t.Errorf("unexpected renditions %+v", p.Renditions)
+	}
+	blob, err := p.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, blob)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, v := range []string{
+		"",
+		"#EXT-X-VERSION:3\n",
+		"#EXTM3U\n#EXTINF:abc,\nseg.ts\n",
+		"#EXTM3U\n#EXT-X-PART:DURATION=1.0,URI=\"unterminated\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\n",
+		"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=x\nvideo.m3u8\n",
+	} {
+		if _, err := Parse([]byte(v)); err == nil {
+			t.Errorf("%q: expected an error", v)
+		}
+	}
+}
@@ -2,20 +2,21 @@ package hls
 
 import (
 	"bytes"
-	"fmt"
 	"net/http"
 	"strings"
 	"time"
+
+	"eaglesong.dev/hls/internal/naming"
+	"eaglesong.dev/hls/m3u8"
 )
 
-func (p *Publisher) serveMainPlaylist(rw http.ResponseWriter, req *http.Request, state hlsState) {
+func (p *Publisher) serveMainPlaylist(rw http.ResponseWriter, req *http.Request, state hlsState, query string) {
 	if p.comboID >= 0 {
 		// serve combined playlist instead
-		p.servePlaylist(rw, req, state, p.comboID)
+		p.servePlaylist(rw, req, state, p.comboID, query)
 		return
 	}
-	var b bytes.Buffer
-	fmt.Fprintln(&b, "#EXTM3U")
+	var pl m3u8.MasterPlaylist
 	var codecs []string
 	for trackID := range state.tracks {
 		if trackID == p.comboID {
@@ -25,15 +26,37 @@ func (p *Publisher) serveMainPlaylist(rw http.ResponseWriter, req *http.Request,
 		if trackID == p.vidx {
 			continue
 		}
-		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"audio\",NAME=\"audio\",DEFAULT=YES,URI=\"%d%s.m3u8\"\n", trackID, p.pid)
+		pl.Renditions = append(pl.Renditions, m3u8.Rendition{
+			Type:    "AUDIO",
+			GroupID: "audio",
+			Name:    "audio",
+			Default: true,
+			URI:     p.playlistName(trackID),
+		})
+	}
+	if state.images.playlist != nil {
+		width, height := p.Thumbnails.size()
+		cols, rows := p.Thumbnails.layout()
+		pl.ImageStreams = append(pl.ImageStreams, m3u8.ImageStream{
+			Bandwidth: p.imageBandwidth(state.images.tiles),
+			Width:     cols * width,
+			Height:    rows * height,
+			Codecs:    "jpeg",
+			URI:       p.names.Format(naming.Name{Track: p.imageTrackID(), Part: -1, Ext: ".m3u8"}),
+		})
 	}
-	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AUDIO=\"audio\",CODECS=\"%s\"\n%d%s.m3u8\n",
-		state.bandwidth, strings.Join(codecs, ","), p.vidx, p.pid)
+	pl.Variants = append(pl.Variants, m3u8.Variant{
+		Bandwidth: state.bandwidth,
+		Audio:     "audio",
+		Codecs:    strings.Join(codecs, ","),
+		URI:       p.playlistName(p.vidx),
+	})
+	blob, _ := pl.MarshalText()
 	rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(b.Bytes()))
+	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(addPlaylistQuery(blob, query)))
 }
 
-func (p *Publisher) serveDASH(rw http.ResponseWriter, req *http.Request, state hlsState) {
+func (p *Publisher) serveDASH(rw http.ResponseWriter, req *http.Request, state hlsState, query string) {
 	if p.BlockMPD {
 		state = p.waitForEtag(req, state)
 	}
@@ -41,7 +64,7 @@ func (p *Publisher) serveDASH(rw http.ResponseWriter, req *http.Request, state h
 		http.NotFound(rw, req)
 		return
 	}
-	r := bytes.NewReader(state.mpd.value)
+	r := bytes.NewReader(addMPDQuery(state.mpd.value, query))
 	rw.Header().Set("Content-Type", "application/dash+xml")
 	rw.Header().Set("Cache-Control", "public, max-age=0, must-revalidate")
 	rw.Header().Set("Etag", state.mpd.etag)
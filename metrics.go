@@ -0,0 +1,102 @@
+package hls
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/hls/internal/metrics"
+	"eaglesong.dev/hls/internal/segment"
+)
+
+// internal counters for monitoring a publisher
+type publisherMetrics struct {
+	segments    metrics.Counter
+	parts       metrics.Counter
+	ingestBytes metrics.Counter
+	servedBytes metrics.Counter
+	expired     metrics.Counter
+	blocked     metrics.Gauge
+	// histograms
+	fragmentLatency  *metrics.Histogram
+	bitrate          *metrics.Histogram
+	keyframeInterval *metrics.Histogram
+	// protected by publisher lock
+	segmentBytes int64         // bytes ingested since the current segment began
+	lastKey      time.Duration // timestamp of the most recent keyframe
+	haveKey      bool
+}
+
+func newPublisherMetrics() *publisherMetrics {
+	return &publisherMetrics{
+		fragmentLatency:  metrics.NewHistogram(0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1),
+		bitrate:          metrics.NewHistogram(250e3, 500e3, 1e6, 2e6, 4e6, 8e6, 16e6, 32e6),
+		keyframeInterval: metrics.NewHistogram(0.5, 1, 2, 3, 4, 5, 6, 8, 10, 15),
+	}
+}
+
+// get the publisher's metrics, creating them on first use
+func (p *Publisher) stats() *publisherMetrics {
+	p.metricsOnce.Do(func() { p.metrics = newPublisherMetrics() })
+	return p.metrics
+}
+
+// account for an incoming packet
+func (p *Publisher) recordPacket(pkt ExtendedPacket) {
+	m := p.stats()
+	m.ingestBytes.Add(int64(len(pkt.Data)))
+	m.segmentBytes += int64(len(pkt.Data))
+	if pkt.IsKeyFrame && int(pkt.Idx) == p.vidx {
+		if interval := pkt.Time - m.lastKey; m.haveKey && interval > 0 {
+			m.keyframeInterval.Observe(interval.Seconds())
+		}
+		m.lastKey = pkt.Time
+		m.haveKey = true
+	}
+}
+
+// account for a segment that was just completed
+func (p *Publisher) recordSegment(seg *segment.Segment) {
+	m := p.stats()
+	m.segments.Inc()
+	if dur := seg.Duration(); dur > 0 {
+		m.bitrate.Observe(float64(m.segmentBytes*8) / dur.Seconds())
+	}
+	m.segmentBytes = 0
+}
+
+// collect the publisher's metrics into a set, labelled with the stream name
+func (p *Publisher) collectMetrics(s *metrics.Set) {
+	m := p.stats()
+	labels := metrics.Labels("stream", p.Name)
+	s.Counter("hls_segments_total", "Segments completed.", labels, m.segments.Value())
+	s.Counter("hls_parts_total", "Parts flushed across all tracks.", labels, m.parts.Value())
+	s.Counter("hls_ingest_bytes_total", "Bytes of media received from the source.", labels, m.ingestBytes.Value())
+	s.Counter("hls_served_bytes_total", "Bytes of media sent to clients.", labels, m.servedBytes.Value())
+	s.Counter("hls_expired_requests_total", "Requests for segments that are no longer available.", labels, m.expired.Value())
+	s.Gauge("hls_blocked_requests", "Requests waiting for a segment or part to become available.", labels, float64(m.blocked.Value()))
+	p.subsMu.Lock()
+	subs := len(p.subs)
+	p.subsMu.Unlock()
+	s.Gauge("hls_subscribers", "Listeners waiting for the playlist to update.", labels, float64(subs))
+	if p.Sessions != nil {
+		s.Gauge("hls_viewers", "Current viewer sessions.", labels, float64(p.Sessions.Stats(p.Name).Viewers))
+	}
+	s.Histogram("hls_fragment_seconds", "Time taken to produce fragments from queued packets.", labels, m.fragmentLatency)
+	s.Histogram("hls_ingest_bitrate_bits_per_second", "Bitrate of the source, measured per segment.", labels, m.bitrate)
+	s.Histogram("hls_keyframe_interval_seconds", "Time between keyframes from the source.", labels, m.keyframeInterval)
+}
+
+// WriteMetrics writes the publisher's metrics in the Prometheus text format
+func (p *Publisher) WriteMetrics(w io.Writer) error {
+	var s metrics.Set
+	p.collectMetrics(&s)
+	_, err := s.WriteTo(w)
+	return err
+}
+
+// ServeMetrics serves the publisher's metrics in the Prometheus text format
+func (p *Publisher) ServeMetrics(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	p.WriteMetrics(rw)
+}
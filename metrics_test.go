@@ -0,0 +1,57 @@
+package hls
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// get the value of the sample with the given name and labels
+func metricValue(t *testing.T, p *Publisher, sample string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := p.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if v := strings.TrimPrefix(line, sample+" "); v != line {
+			return v
+		}
+	}
+	t.Fatalf("no sample %s in:\n%s", sample, buf.String())
+	return ""
+}
+
+func TestServedBytes(t *testing.T) {
+	p := &Publisher{Mode: ModeSeparateTracks, Name: "live"}
+	if err := p.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	f := &feeder{p: p}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	const sample = `hls_served_bytes_total{stream="live"}`
+	if v := metricValue(t, p, sample); v != "0" {
+		t.Errorf("expected nothing served yet, got %s", v)
+	}
+	var served int
+	for _, uri := range []string{p.playlistName(0), p.segmentName(0, p.baseMSN, -1), p.segmentName(1, p.baseMSN, 0)} {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/"+uri, nil))
+		if rec.Code != 200 {
+			t.Fatalf("GET %s: status %d", uri, rec.Code)
+		}
+		if !strings.HasSuffix(uri, ".m3u8") {
+			served += rec.Body.Len()
+		}
+	}
+	// only media is counted, not playlists
+	if v := metricValue(t, p, sample); v != strconv.Itoa(served) {
+		t.Errorf("expected %d bytes served, got %s", served, v)
+	}
+}
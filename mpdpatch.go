@@ -0,0 +1,303 @@
+package hls
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"time"
+
+	"eaglesong.dev/hls/dashmpd"
+)
+
+// number of previous MPDs that clients can patch from
+const mpdPatchHistory = 8
+
+func formatPublishTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// advertise where to get a patch from the current version of the MPD
+func (p *Publisher) setPatchLocation(initialDur time.Duration) {
+	p.mpd.PatchLocation = &dashmpd.PatchLocation{
+		TTL: int((mpdPatchHistory - 1) * initialDur.Seconds()),
+		URL: "main.mpp?publishTime=" + url.QueryEscape(formatPublishTime(*p.mpd.PublishTime)),
+	}
+}
+
+// remember the current MPD and build patches to it from each previous version
+func (p *Publisher) updateMPDPatches() map[string][]byte {
+	p.mpdHistory = append(p.mpdHistory, p.mpd)
+	if len(p.mpdHistory) > mpdPatchHistory {
+		p.mpdHistory = p.mpdHistory[len(p.mpdHistory)-mpdPatchHistory:]
+	}
+	patches := make(map[string][]byte, len(p.mpdHistory))
+	for i := range p.mpdHistory {
+		old := &p.mpdHistory[i]
+		patch, err := mpdPatch(old, &p.mpd)
+		if err != nil {
+			p.warnf("failed to build MPD patch: %s", err)
+			continue
+		}
+		blob, _ := xml.Marshal(patch)
+		patches[formatPublishTime(*old.PublishTime)] = append([]byte(xml.Header), blob...)
+	}
+	return patches
+}
+
+// build a patch that brings a client's copy of an MPD up to date
+func mpdPatch(old, cur *dashmpd.MPD) (*dashmpd.Patch, error) {
+	patch := &dashmpd.Patch{
+		Namespace:           dashmpd.PatchNamespace,
+		MPDID:               cur.ID,
+		OriginalPublishTime: *old.PublishTime,
+		PublishTime:         *cur.PublishTime,
+	}
+	patch.ReplaceValue("/MPD/@publishTime", formatPublishTime(*cur.PublishTime))
+	if *old.MaxSegmentDuration != *cur.MaxSegmentDuration {
+		v, _ := cur.MaxSegmentDuration.MarshalText()
+		patch.ReplaceValue("/MPD/@maxSegmentDuration", string(v))
+	}
+	if cur.PatchLocation != nil {
+		if err := patch.Replace("/MPD/PatchLocation", dashmpd.Element{Name: "PatchLocation", Value: cur.PatchLocation}); err != nil {
+			return nil, err
+		}
+	}
+	oldPeriods := make(map[string]*dashmpd.Period, len(old.Period))
+	for i := range old.Period {
+		oldPeriods[old.Period[i].ID] = &old.Period[i]
+	}
+	var added []dashmpd.Element
+	for i := range cur.Period {
+		period := &cur.Period[i]
+		if prev := oldPeriods[period.ID]; prev != nil {
+			if err := patchPeriod(patch, prev, period); err != nil {
+				return nil, err
+			}
+			delete(oldPeriods, period.ID)
+		} else {
+			added = append(added, dashmpd.Element{Name: "Period", Value: period})
+		}
+	}
+	if len(added) != 0 {
+		if err := patch.Add("/MPD/Period[last()]", "after", added...); err != nil {
+			return nil, err
+		}
+	}
+	for _, period := range old.Period {
+		if oldPeriods[period.ID] != nil {
+			patch.Remove(fmt.Sprintf("/MPD/Period[@id='%s']", period.ID))
+		}
+	}
+	return patch, nil
+}
+
+func patchPeriod(patch *dashmpd.Patch, old, cur *dashmpd.Period) error {
+	sel := fmt.Sprintf("/MPD/Period[@id='%s']", cur.ID)
+	if *old.Start != *cur.Start {
+		v, _ := cur.Start.MarshalText()
+		patch.ReplaceValue(sel+"/@start", string(v))
+	}
+	oldSets := make(map[string]*dashmpd.AdaptationSet, len(old.AdaptationSet))
+	for i := range old.AdaptationSet {
+		oldSets[old.AdaptationSet[i].ID] = &old.AdaptationSet[i]
+	}
+	for i := range cur.AdaptationSet {
+		aset := &cur.AdaptationSet[i]
+		prev := oldSets[aset.ID]
+		if prev == nil {
+			if err := patch.Add(sel, "", dashmpd.Element{Name: "AdaptationSet", Value: aset}); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(oldSets, aset.ID)
+		if err := patchAdaptationSet(patch, fmt.Sprintf("%s/AdaptationSet[@id='%s']", sel, aset.ID), prev, aset); err != nil {
+			return err
+		}
+	}
+	for _, aset := range old.AdaptationSet {
+		if oldSets[aset.ID] != nil {
+			patch.Remove(fmt.Sprintf("%s/AdaptationSet[@id='%s']", sel, aset.ID))
+		}
+	}
+	return nil
+}
+
+func patchAdaptationSet(patch *dashmpd.Patch, sel string, old, cur *dashmpd.AdaptationSet) error {
+	if old.SegmentTemplate == nil || cur.SegmentTemplate == nil {
+		// no timeline to patch
+		return patch.Replace(sel, dashmpd.Element{Name: "AdaptationSet", Value: cur})
+	}
+	// anything besides the timeline and the commonly changing children is
+	// rare, so replace the whole adaptation set if it changed
+	a, b := *old, *cur
+	for _, aset := range []*dashmpd.AdaptationSet{&a, &b} {
+		tmpl := *aset.SegmentTemplate
+		if tmpl.StartNumber != nil {
+			tmpl.StartNumber = new(int)
+		}
+		tmpl.SegmentTimeline = nil
+		aset.SegmentTemplate = &tmpl
+		aset.Representation = nil
+		if aset.ProducerReferenceTime != nil {
+			aset.ProducerReferenceTime = new(dashmpd.ProducerReferenceTime)
+		}
+	}
+	if !reflect.DeepEqual(a, b) || len(old.Representation) != len(cur.Representation) {
+		return patch.Replace(sel, dashmpd.Element{Name: "AdaptationSet", Value: cur})
+	}
+	if !reflect.DeepEqual(old.ProducerReferenceTime, cur.ProducerReferenceTime) {
+		if err := patch.Replace(sel+"/ProducerReferenceTime", dashmpd.Element{Name: "ProducerReferenceTime", Value: cur.ProducerReferenceTime}); err != nil {
+			return err
+		}
+	}
+	for i, rep := range cur.Representation {
+		if !reflect.DeepEqual(old.Representation[i], rep) {
+			if err := patch.Replace(fmt.Sprintf("%s/Representation[@id='%s']", sel, rep.ID), dashmpd.Element{Name: "Representation", Value: rep}); err != nil {
+				return err
+			}
+		}
+	}
+	if num, prev := cur.SegmentTemplate.StartNumber, old.SegmentTemplate.StartNumber; num != nil && (prev == nil || *num != *prev) {
+		patch.ReplaceValue(sel+"/SegmentTemplate/@startNumber", strconv.Itoa(*num))
+	}
+	tlSel := sel + "/SegmentTemplate/SegmentTimeline"
+	if !patchTimeline(patch, tlSel, old.SegmentTemplate.SegmentTimeline, cur.SegmentTemplate.SegmentTimeline) {
+		return patch.Replace(tlSel, dashmpd.Element{Name: "SegmentTimeline", Value: cur.SegmentTemplate.SegmentTimeline})
+	}
+	return nil
+}
+
+// a single segment in a timeline
+type timelineEntry struct {
+	t uint64
+	d int
+}
+
+func expandTimeline(tl *dashmpd.SegmentTimeline) []timelineEntry {
+	var entries []timelineEntry
+	var t uint64
+	for i, s := range tl.Segments {
+		if i == 0 || s.Time != 0 {
+			t = s.Time
+		}
+		for j := 0; j <= s.Repeat; j++ {
+			entries = append(entries, timelineEntry{t, s.Duration})
+			t += uint64(s.Duration)
+		}
+	}
+	return entries
+}
+
+// patch the S elements of a timeline that has had segments trimmed from the
+// beginning and appended to the end. Returns false if it changed some other
+// way, in which case the whole timeline should be replaced.
+func patchTimeline(patch *dashmpd.Patch, sel string, old, cur *dashmpd.SegmentTimeline) bool {
+	if old == nil || cur == nil {
+		return false
+	}
+	oldEntries, curEntries := expandTimeline(old), expandTimeline(cur)
+	if len(curEntries) == 0 {
+		return false
+	}
+	// find how many segments were trimmed and check that the rest are unchanged
+	trimmed := -1
+	for i, e := range oldEntries {
+		if e.t == curEntries[0].t {
+			trimmed = i
+			break
+		}
+	}
+	if trimmed < 0 || len(oldEntries)-trimmed > len(curEntries) {
+		return false
+	}
+	for i, e := range oldEntries[trimmed:] {
+		if curEntries[i].t != e.t {
+			return false
+		} else if curEntries[i].d != e.d && trimmed+i != len(oldEntries)-1 {
+			// only the segment in progress, whose duration was estimated, may change
+			return false
+		}
+	}
+	// count the S elements that were entirely trimmed
+	var removed, covered int
+	for _, s := range old.Segments {
+		covered += s.Repeat + 1
+		if covered > trimmed {
+			break
+		}
+		removed++
+	}
+	kept := old.Segments[removed:]
+	if len(kept) == 0 || len(kept) > len(cur.Segments) {
+		return false
+	}
+	// the last S element may have had its repeat count increased, and new
+	// ones appended after it. the first may have been partially trimmed.
+	result := append([]dashmpd.Segment(nil), old.Segments...)
+	var ops []func() error
+	last := len(kept) - 1
+	if last > 0 && kept[last] != cur.Segments[last] {
+		idx := removed + last
+		result[idx] = cur.Segments[last]
+		ops = append(ops, func() error {
+			return patch.Replace(fmt.Sprintf("%s/S[%d]", sel, idx+1), dashmpd.Element{Name: "S", Value: cur.Segments[last]})
+		})
+	}
+	if appended := cur.Segments[len(kept):]; len(appended) != 0 {
+		result = append(result, appended...)
+		elements := make([]dashmpd.Element, len(appended))
+		for i, s := range appended {
+			elements[i] = dashmpd.Element{Name: "S", Value: s}
+		}
+		ops = append(ops, func() error {
+			return patch.Add(sel, "", elements...)
+		})
+	}
+	if kept[0] != cur.Segments[0] {
+		result[removed] = cur.Segments[0]
+		ops = append(ops, func() error {
+			return patch.Replace(fmt.Sprintf("%s/S[%d]", sel, removed+1), dashmpd.Element{Name: "S", Value: cur.Segments[0]})
+		})
+	}
+	result = result[removed:]
+	if !reflect.DeepEqual(result, cur.Segments) {
+		return false
+	}
+	for _, op := range ops {
+		if op() != nil {
+			return false
+		}
+	}
+	for i := 0; i < removed; i++ {
+		patch.Remove(sel + "/S[1]")
+	}
+	return true
+}
+
+// serve a patch from the client's version of the MPD to the current one
+func (p *Publisher) serveMPDPatch(rw http.ResponseWriter, req *http.Request, state hlsState, query string) {
+	published, err := time.Parse(time.RFC3339Nano, req.URL.Query().Get("publishTime"))
+	if err != nil {
+		http.Error(rw, "invalid publishTime", http.StatusBadRequest)
+		return
+	}
+	key := formatPublishTime(published)
+	if p.BlockMPD && key == state.mpd.published {
+		// nothing has changed yet
+		state = p.waitForMPD(req.Context(), state.mpd.etag)
+	}
+	patch, ok := state.mpd.patches[key]
+	if !ok {
+		// too old, the client must reload the whole MPD
+		http.Error(rw, "", http.StatusGone)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/dash-patch+xml")
+	rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
+	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(addMPDQuery(patch, query)))
+}
@@ -0,0 +1,368 @@
+package hls
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls/dashmpd"
+)
+
+// elements and text that a patch operation may carry
+type patchContent struct {
+	Text                  string `xml:",chardata"`
+	PatchLocation         *dashmpd.PatchLocation
+	Period                []dashmpd.Period
+	AdaptationSet         []dashmpd.AdaptationSet
+	ProducerReferenceTime *dashmpd.ProducerReferenceTime
+	Representation        []dashmpd.Representation
+	SegmentTimeline       *dashmpd.SegmentTimeline
+	S                     []dashmpd.Segment
+}
+
+// apply a patch the way a client would, supporting the selectors that mpdPatch produces
+func applyPatch(mpd *dashmpd.MPD, patch *dashmpd.Patch) error {
+	for _, op := range patch.Operations {
+		if err := applyPatchOp(mpd, op); err != nil {
+			return fmt.Errorf("%s %s: %w", op.XMLName.Local, op.Sel, err)
+		}
+	}
+	return nil
+}
+
+func applyPatchOp(mpd *dashmpd.MPD, op dashmpd.PatchOperation) error {
+	var c patchContent
+	if err := xml.Unmarshal([]byte("<c>"+string(op.Content)+"</c>"), &c); err != nil {
+		return err
+	}
+	remove := op.XMLName.Local == "remove"
+	steps := strings.Split(strings.TrimPrefix(op.Sel, "/MPD/"), "/")
+	switch steps[0] {
+	case "@publishTime":
+		t, err := time.Parse(time.RFC3339Nano, c.Text)
+		mpd.PublishTime = &t
+		return err
+	case "@maxSegmentDuration":
+		mpd.MaxSegmentDuration = new(dashmpd.Duration)
+		return mpd.MaxSegmentDuration.UnmarshalText([]byte(c.Text))
+	case "PatchLocation":
+		mpd.PatchLocation = c.PatchLocation
+		return nil
+	case "Period[last()]":
+		if op.Pos != "after" {
+			return errors.New("unexpected position")
+		}
+		mpd.Period = append(mpd.Period, c.Period...)
+		return nil
+	}
+	pi := selectID(steps[0], "Period", len(mpd.Period), func(i int) string { return mpd.Period[i].ID })
+	if pi < 0 {
+		return errors.New("no such period")
+	}
+	period := &mpd.Period[pi]
+	if len(steps) == 1 {
+		if remove {
+			mpd.Period = append(mpd.Period[:pi], mpd.Period[pi+1:]...)
+		} else {
+			period.AdaptationSet = append(period.AdaptationSet, c.AdaptationSet...)
+		}
+		return nil
+	} else if steps[1] == "@start" {
+		period.Start = new(dashmpd.Duration)
+		return period.Start.UnmarshalText([]byte(c.Text))
+	}
+	ai := selectID(steps[1], "AdaptationSet", len(period.AdaptationSet), func(i int) string { return period.AdaptationSet[i].ID })
+	if ai < 0 {
+		return errors.New("no such adaptation set")
+	}
+	aset := &period.AdaptationSet[ai]
+	if len(steps) == 2 {
+		if remove {
+			period.AdaptationSet = append(period.AdaptationSet[:ai], period.AdaptationSet[ai+1:]...)
+		} else {
+			*aset = c.AdaptationSet[0]
+		}
+		return nil
+	}
+	switch {
+	case steps[2] == "ProducerReferenceTime":
+		aset.ProducerReferenceTime = c.ProducerReferenceTime
+	case strings.HasPrefix(steps[2], "Representation["):
+		ri := selectID(steps[2], "Representation", len(aset.Representation), func(i int) string { return aset.Representation[i].ID })
+		if ri < 0 {
+			return errors.New("no such representation")
+		}
+		aset.Representation[ri] = c.Representation[0]
+	case steps[2] == "SegmentTemplate" && len(steps) > 3 && steps[3] == "@startNumber":
+		n, err := strconv.Atoi(c.Text)
+		aset.SegmentTemplate.StartNumber = dashmpd.Int(n)
+		return err
+	case steps[2] == "SegmentTemplate" && len(steps) > 3 && steps[3] == "SegmentTimeline":
+		return applyTimelineOp(&aset.SegmentTemplate.SegmentTimeline, steps[4:], op, c)
+	default:
+		return errors.New("unsupported selector")
+	}
+	return nil
+}
+
+// apply an operation on a SegmentTimeline or one of its S elements
+func applyTimelineOp(tl **dashmpd.SegmentTimeline, steps []string, op dashmpd.PatchOperation, c patchContent) error {
+	switch {
+	case len(steps) == 0 && op.XMLName.Local == "replace":
+		*tl = c.SegmentTimeline
+	case len(steps) == 0 && op.XMLName.Local == "add":
+		(*tl).Segments = append((*tl).Segments, c.S...)
+	case len(steps) == 1 && strings.HasPrefix(steps[0], "S["):
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(steps[0], "S["), "]"))
+		if err != nil || n < 1 || n > len((*tl).Segments) {
+			return errors.New("no such S element")
+		}
+		if op.XMLName.Local == "remove" {
+			(*tl).Segments = append((*tl).Segments[:n-1], (*tl).Segments[n:]...)
+		} else {
+			(*tl).Segments[n-1] = c.S[0]
+		}
+	default:
+		return errors.New("unsupported selector")
+	}
+	return nil
+}
+
+// find the element selected by a step like Name[@id='x']
+func selectID(step, name string, n int, id func(int) string) int {
+	want := strings.TrimPrefix(step, name+"[@id='")
+	if want == step || !strings.HasSuffix(want, "']") {
+		return -1
+	}
+	want = strings.TrimSuffix(want, "']")
+	for i := 0; i < n; i++ {
+		if id(i) == want {
+			return i
+		}
+	}
+	return -1
+}
+
+var testPublishTime = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testMPD(version int, periods ...dashmpd.Period) *dashmpd.MPD {
+	published := testPublishTime.Add(time.Duration(version) * time.Second)
+	return &dashmpd.MPD{
+		ID:                 "m",
+		Profiles:           "urn:mpeg:dash:profile:isoff-live:2011",
+		Type:               "dynamic",
+		PublishTime:        &published,
+		MaxSegmentDuration: &dashmpd.Duration{Duration: 2 * time.Second},
+		PatchLocation:      &dashmpd.PatchLocation{URL: "main.mpp?publishTime=" + formatPublishTime(published)},
+		Period:             periods,
+	}
+}
+
+func testPeriod(id string, startNumber int, segments ...dashmpd.Segment) dashmpd.Period {
+	return dashmpd.Period{
+		ID:    id,
+		Start: &dashmpd.Duration{},
+		AdaptationSet: []dashmpd.AdaptationSet{{
+			ID:          "0",
+			ContentType: "video",
+			SegmentTemplate: &dashmpd.SegmentTemplate{
+				Media:           "0-x-$Number$.m4s",
+				Initialization:  "0-x-init.mp4",
+				StartNumber:     dashmpd.Int(startNumber),
+				Timescale:       dashmpd.Int(90000),
+				SegmentTimeline: &dashmpd.SegmentTimeline{Segments: segments},
+			},
+			Representation: []dashmpd.Representation{{ID: "v0", Bandwidth: 1000, Codecs: "avc1.640028"}},
+		}},
+	}
+}
+
+func TestPatchTimeline(t *testing.T) {
+	type S = dashmpd.Segment
+	values := []struct {
+		Name     string
+		Old, Cur []S
+		OK       bool
+	}{
+		{"unchanged", []S{{Time: 0, Duration: 2, Repeat: 2}}, []S{{Time: 0, Duration: 2, Repeat: 2}}, true},
+		{"repeat increased", []S{{Time: 0, Duration: 2, Repeat: 1}}, []S{{Time: 0, Duration: 2, Repeat: 2}}, true},
+		{"appended", []S{{Time: 0, Duration: 2, Repeat: 1}}, []S{{Time: 0, Duration: 2, Repeat: 1}, {Duration: 3}}, true},
+		{"first partly trimmed", []S{{Time: 0, Duration: 2, Repeat: 2}, {Duration: 3}}, []S{{Time: 2, Duration: 2, Repeat: 1}, {Duration: 3}}, true},
+		{"first trimmed", []S{{Time: 0, Duration: 2, Repeat: 2}, {Duration: 3}}, []S{{Time: 6, Duration: 3}}, true},
+		{"trimmed and extended", []S{{Time: 0, Duration: 2}, {Duration: 3, Repeat: 1}, {Duration: 2}}, []S{{Time: 2, Duration: 3, Repeat: 1}, {Duration: 2, Repeat: 1}, {Duration: 4}}, true},
+		{"several trimmed", []S{{Time: 0, Duration: 2}, {Duration: 3}, {Duration: 2, Repeat: 1}}, []S{{Time: 7, Duration: 2}, {Duration: 5}}, true},
+		{"past duration changed", []S{{Time: 0, Duration: 2, Repeat: 2}}, []S{{Time: 0, Duration: 2}, {Duration: 3}}, false},
+		{"gap", []S{{Time: 0, Duration: 2, Repeat: 2}}, []S{{Time: 1, Duration: 2, Repeat: 2}}, false},
+		{"all trimmed", []S{{Time: 0, Duration: 2}}, []S{{Time: 10, Duration: 2}}, false},
+		{"empty", []S{{Time: 0, Duration: 2}}, nil, false},
+	}
+	const sel = "/MPD/SegmentTimeline"
+	for _, ex := range values {
+		patch := new(dashmpd.Patch)
+		old := &dashmpd.SegmentTimeline{Segments: append([]S(nil), ex.Old...)}
+		cur := &dashmpd.SegmentTimeline{Segments: ex.Cur}
+		ok := patchTimeline(patch, sel, old, cur)
+		if ok != ex.OK {
+			t.Errorf("%s: expected %t, got %t", ex.Name, ex.OK, ok)
+			continue
+		} else if !ok {
+			continue
+		}
+		for _, op := range patch.Operations {
+			var c patchContent
+			if err := xml.Unmarshal([]byte("<c>"+string(op.Content)+"</c>"), &c); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyTimelineOp(&old, strings.Split(strings.TrimPrefix(op.Sel, sel), "/")[1:], op, c); err != nil {
+				t.Fatalf("%s: %s %s: %s", ex.Name, op.XMLName.Local, op.Sel, err)
+			}
+		}
+		if fmt.Sprint(old.Segments) != fmt.Sprint(ex.Cur) {
+			t.Errorf("%s: patched timeline is %v, expected %v", ex.Name, old.Segments, ex.Cur)
+		}
+	}
+}
+
+func TestMPDPatch(t *testing.T) {
+	type S = dashmpd.Segment
+	values := []struct {
+		Name     string
+		Old, Cur func() *dashmpd.MPD
+		Ops      int
+	}{
+		{
+			"timeline advanced",
+			func() *dashmpd.MPD { return testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2, Repeat: 2})) },
+			func() *dashmpd.MPD { return testMPD(1, testPeriod("p0", 1, S{Time: 2, Duration: 2, Repeat: 2})) },
+			// publishTime, PatchLocation, startNumber, and the only S element
+			4,
+		},
+		{
+			"period added",
+			func() *dashmpd.MPD { return testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2, Repeat: 2})) },
+			func() *dashmpd.MPD {
+				next := testPeriod("p3", 3, S{Time: 900, Duration: 2})
+				next.Start = &dashmpd.Duration{Duration: 6 * time.Second}
+				return testMPD(1, testPeriod("p0", 0, S{Time: 0, Duration: 2, Repeat: 2}), next)
+			},
+			3,
+		},
+		{
+			"period expired",
+			func() *dashmpd.MPD {
+				return testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2}), testPeriod("p1", 1, S{Time: 900, Duration: 2}))
+			},
+			func() *dashmpd.MPD { return testMPD(1, testPeriod("p1", 1, S{Time: 900, Duration: 2, Repeat: 1})) },
+			4,
+		},
+		{
+			"representation changed",
+			func() *dashmpd.MPD { return testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2})) },
+			func() *dashmpd.MPD {
+				mpd := testMPD(1, testPeriod("p0", 0, S{Time: 0, Duration: 2}))
+				mpd.Period[0].AdaptationSet[0].Representation[0].Bandwidth = 2000
+				mpd.MaxSegmentDuration = &dashmpd.Duration{Duration: 3 * time.Second}
+				return mpd
+			},
+			4,
+		},
+		{
+			"adaptation set changed",
+			func() *dashmpd.MPD { return testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2})) },
+			func() *dashmpd.MPD {
+				mpd := testMPD(1, testPeriod("p0", 0, S{Time: 0, Duration: 2}))
+				mpd.Period[0].AdaptationSet[0].MaxWidth = 1920
+				mpd.Period[0].AdaptationSet = append(mpd.Period[0].AdaptationSet, dashmpd.AdaptationSet{ID: "1", ContentType: "audio"})
+				return mpd
+			},
+			4,
+		},
+		{
+			"start number added",
+			func() *dashmpd.MPD {
+				mpd := testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2}))
+				mpd.Period[0].AdaptationSet[0].SegmentTemplate.StartNumber = nil
+				return mpd
+			},
+			func() *dashmpd.MPD { return testMPD(1, testPeriod("p0", 1, S{Time: 0, Duration: 2})) },
+			3,
+		},
+		{
+			"start number removed",
+			func() *dashmpd.MPD { return testMPD(0, testPeriod("p0", 1, S{Time: 0, Duration: 2})) },
+			func() *dashmpd.MPD {
+				mpd := testMPD(1, testPeriod("p0", 0, S{Time: 0, Duration: 2}))
+				mpd.Period[0].AdaptationSet[0].SegmentTemplate.StartNumber = nil
+				return mpd
+			},
+			3,
+		},
+		{
+			"template added",
+			func() *dashmpd.MPD {
+				mpd := testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2}))
+				mpd.Period[0].AdaptationSet[0].SegmentTemplate = nil
+				return mpd
+			},
+			func() *dashmpd.MPD { return testMPD(1, testPeriod("p0", 0, S{Time: 0, Duration: 2})) },
+			3,
+		},
+		{
+			"template removed",
+			func() *dashmpd.MPD { return testMPD(0, testPeriod("p0", 0, S{Time: 0, Duration: 2})) },
+			func() *dashmpd.MPD {
+				mpd := testMPD(1, testPeriod("p0", 0, S{Time: 0, Duration: 2}))
+				mpd.Period[0].AdaptationSet[0].SegmentTemplate = nil
+				return mpd
+			},
+			3,
+		},
+	}
+	for _, ex := range values {
+		old, cur := ex.Old(), ex.Cur()
+		patch, err := mpdPatch(old, cur)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(patch.Operations) != ex.Ops {
+			var sels []string
+			for _, op := range patch.Operations {
+				sels = append(sels, op.XMLName.Local+" "+op.Sel)
+			}
+			t.Errorf("%s: expected %d operations, got %q", ex.Name, ex.Ops, sels)
+		}
+		if _, err := xml.Marshal(patch); err != nil {
+			t.Fatal(err)
+		}
+		patched := ex.Old()
+		if err := applyPatch(patched, patch); err != nil {
+			t.Errorf("%s: %s", ex.Name, err)
+			continue
+		}
+		expected, _ := xml.Marshal(cur)
+		got, _ := xml.Marshal(patched)
+		if string(got) != string(expected) {
+			t.Errorf("%s: patched MPD differs\nexpected: %s\ngot:      %s", ex.Name, expected, got)
+		}
+	}
+}
+
+func TestPatchLocationQuery(t *testing.T) {
+	blob, err := xml.Marshal(testMPD(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob = addMPDQuery(blob, "token=a&b=c")
+	var mpd dashmpd.MPD
+	if err := xml.Unmarshal(blob, &mpd); err != nil {
+		t.Fatalf("%s: %s", err, blob)
+	}
+	expected := "main.mpp?publishTime=" + formatPublishTime(testPublishTime) + "&token=a&b=c"
+	if mpd.PatchLocation.URL != expected {
+		t.Errorf("expected %q, got %q", expected, mpd.PatchLocation.URL)
+	}
+}
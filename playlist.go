@@ -2,12 +2,16 @@ package hls
 
 import (
 	"bytes"
-	"fmt"
 	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"eaglesong.dev/hls/internal/naming"
 	"eaglesong.dev/hls/internal/segment"
+	"eaglesong.dev/hls/internal/timescale"
+	"eaglesong.dev/hls/m3u8"
 )
 
 const maxFutureMSN = 3
@@ -18,13 +22,18 @@ type hlsState struct {
 	first     segment.MSN
 	complete  segment.PartMSN
 	bandwidth int
+	images    imageSnapshot
+
+	muxHeaders []trackHeader
 
 	mpd cachedMPD
 }
 
 type trackSnapshot struct {
-	segments []segment.Cursor
-	playlist []byte
+	segments  []segment.Cursor
+	headers   []trackHeader
+	playlist  []byte
+	timeScale uint32
 }
 
 func (s *hlsState) Valid() bool {
@@ -53,6 +62,82 @@ func (s *hlsState) Get(msn segment.MSN, trackID int) (c segment.Cursor, ok bool)
 	return s.tracks[trackID].segments[idx], true
 }
 
+// ParseMSN resolves the segment part of a filename, which is either a number or
+// "t" followed by the segment's presentation time in the track's timescale. A
+// time after the start of the newest segment resolves to the next one, which
+// hasn't begun yet, so StartsAt must be checked once it is available.
+func (s *hlsState) ParseMSN(v string, trackID int) (msn segment.MSN, ok bool) {
+	if !strings.HasPrefix(v, "t") {
+		num, err := strconv.ParseInt(v, 10, 64)
+		return segment.MSN(num), err == nil
+	}
+	t, err := strconv.ParseUint(v[1:], 10, 64)
+	if err != nil || !s.Valid() {
+		return 0, false
+	}
+	track := s.tracks[trackID]
+	// timestamps may repeat after a discontinuity, so prefer the newest
+	for i := len(track.segments) - 1; i >= 0; i-- {
+		start := timescale.ToScale(track.segments[i].Start(), track.timeScale)
+		if start == t {
+			return s.first + segment.MSN(i), true
+		} else if start < t {
+			if i == len(track.segments)-1 {
+				// a segment that hasn't begun yet
+				return s.first + segment.MSN(i+1), true
+			}
+			break
+		}
+	}
+	return 0, false
+}
+
+// StartsAt checks that a segment addressed by presentation time actually begins
+// at that time. Segments addressed by number always match.
+func (s *hlsState) StartsAt(v string, trackID int, start time.Duration) bool {
+	if !strings.HasPrefix(v, "t") {
+		return true
+	}
+	t, err := strconv.ParseUint(v[1:], 10, 64)
+	return err == nil && timescale.ToScale(start, s.tracks[trackID].timeScale) == t
+}
+
+// Header gets an initialization segment by filename
+func (s *hlsState) Header(name string, trackID int) (hdr trackHeader, ok bool) {
+	if !s.Valid() {
+		return
+	}
+	for _, hdr := range s.tracks[trackID].headers {
+		if hdr.name == name {
+			return hdr, true
+		}
+	}
+	return
+}
+
+// HeaderFor gets the initialization segment used by the given MSN
+func (s *hlsState) HeaderFor(msn segment.MSN, trackID int) (hdr trackHeader) {
+	if !s.Valid() {
+		return
+	}
+	return headerAt(s.tracks[trackID].headers, msn)
+}
+
+// MuxHeaderFor gets the initialization segment covering all separate tracks, used by the given MSN
+func (s *hlsState) MuxHeaderFor(msn segment.MSN) trackHeader {
+	return headerAt(s.muxHeaders, msn)
+}
+
+func headerAt(headers []trackHeader, msn segment.MSN) (hdr trackHeader) {
+	for _, h := range headers {
+		if h.msn > msn {
+			break
+		}
+		hdr = h
+	}
+	return
+}
+
 // publish a lock-free snapshot of segments and playlist
 func (p *Publisher) snapshot(initialDur time.Duration) {
 	if initialDur == 0 {
@@ -65,15 +150,19 @@ func (p *Publisher) snapshot(initialDur time.Duration) {
 	} else if fragLen == 0 {
 		fragLen = defaultFragmentLength
 	}
+	partTarget := fragLen
+	if fragLen > 0 {
+		partTarget = p.partTarget(fragLen)
+	}
 	completeIndex := -1
 	completeParts := -1
 	var totalSize int64
 	var totalDur float64
 	tracks := make([]trackSnapshot, len(p.tracks))
 	for trackID, track := range p.tracks {
-		var b bytes.Buffer
-		p.formatTrackHeader(&b, trackID, initialDur, fragLen)
+		pl := p.trackPlaylist(initialDur, partTarget)
 		cursors := make([]segment.Cursor, len(track.segments))
+		var prevHeader string
 		for i, seg := range track.segments {
 			cursors[i] = seg.Cursor()
 			if seg.Final() {
@@ -86,11 +175,26 @@ func (p *Publisher) snapshot(initialDur time.Duration) {
 				completeParts = seg.Parts()
 			}
 			includeParts := fragLen > 0 && i >= len(track.segments)-3
-			seg.Format(&b, includeParts)
+			// reference the initialization segment before the first segment and wherever it changes
+			header := track.headerName(p.baseMSN + segment.MSN(i))
+			var entry m3u8.Segment
+			var ok bool
+			if header == prevHeader {
+				entry, ok = seg.PlaylistEntry("", includeParts)
+			} else {
+				entry, ok = seg.PlaylistEntry(naming.Rel(p.playlistName(trackID), header), includeParts)
+				prevHeader = header
+			}
+			if ok {
+				pl.Segments = append(pl.Segments, entry)
+			}
 		}
+		blob, _ := pl.MarshalText()
 		tracks[trackID] = trackSnapshot{
-			segments: cursors,
-			playlist: b.Bytes(),
+			segments:  cursors,
+			headers:   append([]trackHeader(nil), track.headers...),
+			playlist:  blob,
+			timeScale: track.frag.TimeScale(),
 		}
 	}
 	var bandwidth float64
@@ -103,9 +207,11 @@ func (p *Publisher) snapshot(initialDur time.Duration) {
 		mpd = p.updateMPD(initialDur)
 	}
 	p.prev = hlsState{
-		tracks:    tracks,
-		bandwidth: int(bandwidth),
-		first:     p.baseMSN,
+		tracks:     tracks,
+		bandwidth:  int(bandwidth),
+		images:     p.imageSnapshot(),
+		muxHeaders: append([]trackHeader(nil), p.muxHeaders...),
+		first:      p.baseMSN,
 		complete: segment.PartMSN{
 			MSN:  completeMSN,
 			Part: completeParts,
@@ -116,26 +222,42 @@ func (p *Publisher) snapshot(initialDur time.Duration) {
 	p.notifySegment()
 }
 
-func (p *Publisher) formatTrackHeader(b *bytes.Buffer, trackID int, initialDur, fragLen time.Duration) {
-	ver := 9
-	if fragLen <= 0 {
-		ver = 3
+// calculate the longest part duration, which is at least the fragment length.
+// parts end at the first frame after the fragment length, so they overrun it
+// when a track's frames don't divide it evenly.
+func (p *Publisher) partTarget(fragLen time.Duration) time.Duration {
+	maxTime := fragLen
+	for _, track := range p.tracks {
+		for _, seg := range track.segments {
+			if dur := seg.LongestPart(); dur > maxTime {
+				maxTime = dur
+			}
+		}
 	}
-	fmt.Fprintf(b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n", ver, int(math.Round(initialDur.Seconds())))
-	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.baseMSN)
-	if p.baseDCN != 0 {
-		fmt.Fprintf(b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.baseDCN)
+	return maxTime
+}
+
+func (p *Publisher) trackPlaylist(initialDur, partTarget time.Duration) *m3u8.MediaPlaylist {
+	pl := &m3u8.MediaPlaylist{
+		Version:               9,
+		TargetDuration:        int(math.Round(initialDur.Seconds())),
+		MediaSequence:         int64(p.baseMSN),
+		DiscontinuitySequence: int64(p.baseDCN),
 	}
-	if fragLen > 0 {
-		fmt.Fprintf(b, "#EXT-X-SERVER-CONTROL:HOLD-BACK=%f,PART-HOLD-BACK=%f,CAN-BLOCK-RELOAD=YES\n", 1.5*initialDur.Seconds(), 2.1*fragLen.Seconds())
-		fmt.Fprintf(b, "#EXT-X-PART-INF:PART-TARGET=%f\n", fragLen.Seconds())
+	if partTarget <= 0 {
+		pl.Version = 3
+		return pl
 	}
-	if filename := p.tracks[trackID].hdr.HeaderName; filename != "" {
-		fmt.Fprintf(b, "#EXT-X-MAP:URI=\"%d%s%s\"\n", trackID, p.pid, filename)
+	pl.ServerControl = &m3u8.ServerControl{
+		HoldBack:       3 * time.Duration(pl.TargetDuration) * time.Second,
+		PartHoldBack:   time.Duration(2.1 * float64(partTarget)),
+		CanBlockReload: true,
 	}
+	pl.PartTarget = partTarget
+	return pl
 }
 
-func (p *Publisher) servePlaylist(rw http.ResponseWriter, req *http.Request, state hlsState, trackID int) {
+func (p *Publisher) servePlaylist(rw http.ResponseWriter, req *http.Request, state hlsState, trackID int, query string) {
 	want, err := parseBlock(req.URL.Query())
 	if err != nil {
 		http.Error(rw, err.Error(), 400)
@@ -153,5 +275,5 @@ func (p *Publisher) servePlaylist(rw http.ResponseWriter, req *http.Request, sta
 		}
 	}
 	rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
-	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(state.tracks[trackID].playlist))
+	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(addPlaylistQuery(state.tracks[trackID].playlist, query)))
 }
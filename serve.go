@@ -17,57 +17,92 @@ func (p *Publisher) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		http.NotFound(rw, req)
 		return
 	}
-	// filename is prefixed with track ID, or 'm' for main playlist
 	bn := path.Base(req.URL.Path)
 	if bn == "time" {
 		serveTime(rw)
 		return
 	}
-	track := bn[0]
-	bn = bn[1:]
-	if track == 'm' || track == 'i' {
-		switch path.Ext(bn) {
-		case ".m3u8":
-			// main playlist
-			p.serveMainPlaylist(rw, req, state)
-		case ".mpd":
-			// DASH MPD
-			p.serveDASH(rw, req, state)
-		default:
-			http.NotFound(rw, req)
+	query, ok := p.authorize(rw, req)
+	if !ok {
+		return
+	}
+	var sess *session
+	if p.Sessions != nil {
+		sess = p.Sessions.touch(req, p.Name, query)
+	}
+	switch bn {
+	case "snapshot.mp4":
+		p.serveSnapshot(rw, req, false)
+		return
+	case "snapshot.h264":
+		p.serveSnapshot(rw, req, true)
+		return
+	}
+	name, matched := p.names.Match(req.URL.Path)
+	if !matched {
+		// main playlist is prefixed with 'm', or 'i' for index
+		if bn[0] == 'm' || bn[0] == 'i' {
+			switch path.Ext(bn) {
+			case ".m3u8":
+				// main playlist
+				p.serveMainPlaylist(rw, req, state, query)
+				return
+			case ".mpd":
+				// DASH MPD
+				p.serveDASH(rw, req, state, query)
+				return
+			case ".mpp":
+				// DASH MPD patch
+				p.serveMPDPatch(rw, req, state, query)
+				return
+			}
 		}
+		http.NotFound(rw, req)
 		return
 	}
-	trackID := int(track - '0')
-	if trackID < 0 || trackID >= len(p.tracks) {
+	trackID := name.Track
+	if trackID == len(state.tracks) && p.Thumbnails != nil {
+		p.serveImage(rw, req, state, name, query)
+		return
+	} else if trackID < 0 || trackID >= len(state.tracks) {
 		http.NotFound(rw, req)
 		return
 	}
-	switch path.Ext(bn) {
-	case ".m3u8":
+	if name.MSN != "" {
+		cw := &countingWriter{ResponseWriter: rw}
+		rw = cw
+		defer func() {
+			p.stats().servedBytes.Add(cw.n)
+			if sess != nil {
+				p.Sessions.addBytes(sess, trackID, cw.n)
+			}
+		}()
+	}
+	switch {
+	case name.MSN == "" && name.Ext == ".m3u8":
 		// media playlist
-		p.servePlaylist(rw, req, state, trackID)
+		p.servePlaylist(rw, req, state, trackID, query)
 		return
-	case ".mp4":
+	case strings.HasPrefix(name.MSN, "init"):
 		// initialization segment
-		h := p.tracks[trackID].hdr
+		h, ok := state.Header(p.names.Format(name), trackID)
+		if !ok {
+			break
+		}
 		rw.Header().Set("Content-Type", h.HeaderContentType)
 		http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(h.HeaderContents))
 		return
-	case ".m4s", ".ts":
+	case name.MSN != "":
 		// media segment
-		if !strings.HasPrefix(bn, p.pid) {
-			http.NotFound(rw, req)
-			return
-		}
-		bn = strings.TrimPrefix(bn, p.pid)
-		msn, ok := segment.ParseName(bn)
+		num, ok := state.ParseMSN(name.MSN, trackID)
 		if !ok {
 			break
 		}
+		msn := segment.PartMSN{MSN: num, Part: name.Part}
 		cursor, waitable := state.Get(msn.MSN, trackID)
 		if !waitable {
 			// expired
+			p.stats().expired.Inc()
 			break
 		} else if !cursor.Valid() {
 			// wait for it to become available
@@ -80,7 +115,7 @@ func (p *Publisher) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			state = p.waitForSegment(req.Context(), wait)
 			cursor, _ = state.Get(msn.MSN, trackID)
 		}
-		if cursor.Valid() {
+		if cursor.Valid() && state.StartsAt(name.MSN, trackID, cursor.Start()) {
 			cursor.Serve(rw, req, msn.Part, false)
 			return
 		}
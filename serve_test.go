@@ -0,0 +1,71 @@
+package hls
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls/internal/naming"
+)
+
+func TestRouting(t *testing.T) {
+	// more tracks than fit in a single digit
+	streams := testStreams()
+	for len(streams) < 12 {
+		streams = append(streams, streams[1])
+	}
+	for _, tmpl := range []string{"", "{track}-{pid}-{msn}{part}{ext}", "{track}/{pid}/{msn}{part}{ext}"} {
+		p := &Publisher{Mode: ModeSeparateTracks, URLTemplate: tmpl}
+		if err := p.WriteHeader(streams); err != nil {
+			t.Fatal(err)
+		}
+		f := &feeder{p: p}
+		if err := f.until(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		get := func(uri string) string {
+			rec := httptest.NewRecorder()
+			p.ServeHTTP(rec, httptest.NewRequest("GET", uri, nil))
+			if rec.Code != 200 {
+				t.Errorf("%q: GET %s: status %d", tmpl, uri, rec.Code)
+			}
+			return rec.Body.String()
+		}
+		for trackID := range streams {
+			playlist := "/" + p.playlistName(trackID)
+			body := get(playlist)
+			// the playlist must be the requested track's, so it lists that track's segments
+			segName := naming.Rel(playlist[1:], p.segmentName(trackID, p.baseMSN, -1))
+			if !strings.Contains(body, "\n"+segName+"\n") {
+				t.Errorf("%q: playlist %s doesn't list %s:\n%s", tmpl, playlist, segName, body)
+				continue
+			}
+			base, _ := url.Parse(playlist)
+			ref, _ := url.Parse(segName)
+			// every track's segments are a different size
+			seg := get(base.ResolveReference(ref).Path)
+			if expected := p.tracks[trackID].segments[0].Size(); int64(len(seg)) != expected {
+				t.Errorf("%q: segment %s of track %d is %d bytes, expected %d", tmpl, segName, trackID, len(seg), expected)
+			}
+		}
+		p.Close()
+	}
+}
+
+func TestInstanceID(t *testing.T) {
+	for _, ex := range []struct {
+		T time.Time
+		V string
+	}{
+		{testEpoch, "t85s00"},
+		// all digits, which wouldn't separate the track and segment numbers
+		{time.Unix(0, 0), "p0"},
+		{time.Unix(36*36*36*36*36*36, 0), "p1000000"},
+	} {
+		if v := instanceID(ex.T); v != ex.V {
+			t.Errorf("%s: expected %q, got %q", ex.T, ex.V, v)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package hls
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"eaglesong.dev/hls/internal/metrics"
+	"github.com/nareix/joy4/av"
+)
+
+const defaultIdleTimeout = 30 * time.Second
+
+// ErrSourceReplaced is returned when writing to a Source that has been superseded by a newer one for the same stream
+var ErrSourceReplaced = errors.New("stream was taken over by another source")
+
+// Server hosts a set of named streams, each backed by its own Publisher.
+//
+// Requests are routed by the first path element, so the server should be
+// mounted with http.StripPrefix if it is not at the root. The stream listing
+// and metrics are served by separate handlers, ServeStreams and ServeMetrics,
+// because they bypass each stream's Authorizer.
+type Server struct {
+	// NewPublisher is called to configure the publisher for a stream when it is first published. If nil then default settings are used.
+	NewPublisher func(name string) *Publisher
+	// IdleTimeout is how long a stream is retained after its source disconnects, so that a reconnecting source can resume it. Defaults to 30s.
+	IdleTimeout time.Duration
+
+	mu      sync.Mutex
+	streams map[string]*serverStream
+}
+
+type serverStream struct {
+	name    string
+	created time.Time
+
+	mu      sync.Mutex
+	pub     *Publisher
+	source  *Source // currently active source, or nil if idle
+	idle    *time.Timer
+	codecs  []av.CodecData
+	vidx    int
+	last    time.Duration // timestamp of the last video frame
+	lastDur time.Duration // duration of the last video frame
+}
+
+// Source publishes a stream to a Server. It implements av.Muxer.
+type Source struct {
+	srv    *Server
+	st     *serverStream
+	resume bool          // continuing a stream from a previous source
+	offset time.Duration // added to timestamps to continue where the previous source left off
+}
+
+// Publish begins publishing the named stream. If the stream is already being
+// published then the new source takes over and the old one is cut off. Viewers
+// of the stream see a discontinuity rather than losing the stream.
+func (s *Server) Publish(name string) *Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[string]*serverStream)
+	}
+	st := s.streams[name]
+	if st == nil {
+		st = &serverStream{name: name, created: time.Now()}
+		s.streams[name] = st
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.idle != nil {
+		st.idle.Stop()
+		st.idle = nil
+	}
+	src := &Source{srv: s, st: st}
+	st.source = src
+	return src
+}
+
+// Get returns the publisher for the named stream, or nil if it does not exist
+func (s *Server) Get(name string) *Publisher {
+	s.mu.Lock()
+	st := s.streams[name]
+	s.mu.Unlock()
+	if st == nil {
+		return nil
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.pub
+}
+
+func (s *Server) newPublisher(name string) *Publisher {
+	if s.NewPublisher != nil {
+		return s.NewPublisher(name)
+	}
+	return new(Publisher)
+}
+
+// remove a stream that has gone idle
+func (s *Server) reap(st *serverStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.source != nil || s.streams[st.name] != st {
+		// resumed or already removed
+		return
+	}
+	delete(s.streams, st.name)
+	if st.pub != nil {
+		st.pub.Close()
+		st.pub = nil
+	}
+}
+
+// WriteHeader initializes the stream's codec data
+func (src *Source) WriteHeader(streams []av.CodecData) error {
+	st := src.st
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.source != src {
+		return ErrSourceReplaced
+	}
+	if st.pub != nil && sameLayout(st.codecs, streams) {
+		// continue the existing stream after a discontinuity
+		if err := st.pub.WriteHeader(streams); err != nil {
+			return err
+		}
+		src.resume = true
+		st.codecs = streams
+		return nil
+	}
+	if st.pub != nil {
+		// stream layout changed so existing viewers can't continue
+		st.pub.Close()
+	}
+	pub := src.srv.newPublisher(st.name)
+	pub.Name = st.name
+	if err := pub.WriteHeader(streams); err != nil {
+		st.pub = nil
+		return err
+	}
+	st.pub = pub
+	st.codecs = streams
+	st.last, st.lastDur = 0, 0
+	for i, cd := range streams {
+		if cd.Type().IsVideo() {
+			st.vidx = i
+		}
+	}
+	return nil
+}
+
+// WritePacket publishes a single packet
+func (src *Source) WritePacket(pkt av.Packet) error {
+	return src.WriteExtendedPacket(ExtendedPacket{Packet: pkt})
+}
+
+// WriteExtendedPacket publishes a packet with additional metadata
+func (src *Source) WriteExtendedPacket(pkt ExtendedPacket) error {
+	st := src.st
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.source != src {
+		return ErrSourceReplaced
+	} else if st.pub == nil {
+		return errors.New("WriteHeader was not called")
+	}
+	if src.resume {
+		// place the first packet right after the last one from the previous source
+		src.offset = st.last + st.lastDur - pkt.Time
+		src.resume = false
+	}
+	pkt.Time += src.offset
+	if int(pkt.Idx) == st.vidx && pkt.Time > st.last {
+		st.lastDur = pkt.Time - st.last
+		st.last = pkt.Time
+	}
+	return st.pub.WriteExtendedPacket(pkt)
+}
+
+// WriteTrailer does nothing, but fulfills av.Muxer
+func (src *Source) WriteTrailer() error {
+	return nil
+}
+
+// Close ends the publishing session. If no other source takes over within
+// the server's IdleTimeout then the stream is removed.
+func (src *Source) Close() {
+	st := src.st
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.source != src {
+		return
+	}
+	st.source = nil
+	timeout := src.srv.IdleTimeout
+	if timeout <= 0 {
+		timeout = defaultIdleTimeout
+	}
+	st.idle = time.AfterFunc(timeout, func() { src.srv.reap(st) })
+}
+
+// StreamInfo describes one stream hosted by a Server
+type StreamInfo struct {
+	Name     string    `json:"name"`
+	Active   bool      `json:"active"`
+	Created  time.Time `json:"created"`
+	Playlist string    `json:"playlist"`
+	MPD      string    `json:"mpd,omitempty"`
+	Viewers  int       `json:"viewers,omitempty"`
+}
+
+// Streams lists the streams currently hosted by the server
+func (s *Server) Streams() []StreamInfo {
+	s.mu.Lock()
+	streams := make([]*serverStream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+	infos := make([]StreamInfo, 0, len(streams))
+	for _, st := range streams {
+		st.mu.Lock()
+		info := StreamInfo{
+			Name:    st.name,
+			Active:  st.source != nil,
+			Created: st.created,
+		}
+		if st.pub != nil {
+			info.Playlist = st.name + "/" + st.pub.Playlist()
+			if mpd := st.pub.MPD(); mpd != "" {
+				info.MPD = st.name + "/" + mpd
+			}
+			if st.pub.Sessions != nil {
+				info.Viewers = st.pub.Sessions.Stats(st.name).Viewers
+			}
+		}
+		st.mu.Unlock()
+		if info.Playlist != "" {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// ServeStreams lists the hosted streams as JSON. It reveals the names and
+// audience of every stream regardless of their Authorizer, so it should be
+// mounted where only trusted clients can reach it.
+func (s *Server) ServeStreams(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
+	json.NewEncoder(rw).Encode(s.Streams())
+}
+
+// ServeMetrics serves the metrics of all streams in the Prometheus text
+// format. Like ServeStreams, it should be mounted where only trusted clients
+// can reach it.
+func (s *Server) ServeMetrics(rw http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	streams := make([]*serverStream, 0, len(s.streams))
+	for _, st := range s.streams {
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+	sort.Slice(streams, func(i, j int) bool { return streams[i].name < streams[j].name })
+	var set metrics.Set
+	for _, st := range streams {
+		st.mu.Lock()
+		pub := st.pub
+		st.mu.Unlock()
+		if pub != nil {
+			pub.collectMetrics(&set)
+		}
+	}
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	set.WriteTo(rw)
+}
+
+// route a request to the named stream's publisher
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	p := strings.TrimPrefix(req.URL.Path, "/")
+	name, rest, _ := strings.Cut(p, "/")
+	pub := s.Get(name)
+	if pub == nil || rest == "" {
+		http.NotFound(rw, req)
+		return
+	}
+	switch rest {
+	case "tail":
+		pub.Tail(rw, req)
+		return
+	case "ws":
+		pub.ServeWebSocket(rw, req)
+		return
+	case "events":
+		pub.ServeEvents(rw, req)
+		return
+	}
+	pub.ServeHTTP(rw, req)
+}
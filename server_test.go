@@ -0,0 +1,139 @@
+package hls
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerPublish(t *testing.T) {
+	srv := &Server{}
+	src := srv.Publish("live")
+	defer src.Close()
+	if err := src.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	f := &feeder{src: src}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	pub := srv.Get("live")
+	if pub == nil || pub.Name != "live" {
+		t.Fatalf("expected publisher for stream, got %+v", pub)
+	}
+	get := func(uri string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest("GET", uri, nil))
+		return rec
+	}
+	if rec := get("/live/" + pub.Playlist()); rec.Code != 200 {
+		t.Errorf("expected playlist, got %d", rec.Code)
+	}
+	for _, uri := range []string{"/other/" + pub.Playlist(), "/live", "/live/"} {
+		if rec := get(uri); rec.Code != 404 {
+			t.Errorf("%s: expected 404, got %d", uri, rec.Code)
+		}
+	}
+	// the listing and metrics aren't authorized, so they must be mounted separately
+	for _, uri := range []string{"/", "/metrics"} {
+		if rec := get(uri); rec.Code != 404 {
+			t.Errorf("%s: expected 404, got %d", uri, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	srv.ServeStreams(rec, httptest.NewRequest("GET", "/", nil))
+	var infos []StreamInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name != "live" || !infos[0].Active || infos[0].Playlist != "live/main.m3u8" {
+		t.Errorf("unexpected listing: %+v", infos)
+	}
+	rec = httptest.NewRecorder()
+	srv.ServeMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `hls_segments_total{stream="live"}`) {
+		t.Errorf("expected metrics for the stream, got:\n%s", rec.Body)
+	}
+}
+
+func TestServerTakeover(t *testing.T) {
+	srv := &Server{}
+	src1 := srv.Publish("live")
+	if err := src1.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	f := &feeder{src: src1}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	pub := srv.Get("live")
+	// a new source for the same stream cuts off the old one
+	src2 := srv.Publish("live")
+	defer src2.Close()
+	if err := f.frame(); err != ErrSourceReplaced {
+		t.Errorf("expected old source to be replaced, got %v", err)
+	}
+	src1.Close()
+	if err := src2.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Get("live") != pub {
+		t.Fatal("expected the new source to continue the existing publisher")
+	}
+	// timestamps restart at zero but continue where the old source left off
+	last := pub.primary.current().Start()
+	f = &feeder{src: src2}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	pub.mu.Lock()
+	var resumed bool
+	for _, seg := range pub.primary.segments {
+		if seg.Discontinuous() {
+			resumed = true
+			if seg.Start() <= last {
+				t.Errorf("resumed segment starts at %s, before the last one at %s", seg.Start(), last)
+			}
+		}
+	}
+	pub.mu.Unlock()
+	if !resumed {
+		t.Error("expected a discontinuity where the new source took over")
+	}
+}
+
+func TestServerIdle(t *testing.T) {
+	srv := &Server{IdleTimeout: 50 * time.Millisecond}
+	src := srv.Publish("live")
+	if err := src.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	pub := srv.Get("live")
+	// a source reconnecting within the timeout resumes the stream
+	src.Close()
+	src = srv.Publish("live")
+	time.Sleep(100 * time.Millisecond)
+	if err := src.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.Get("live") != pub {
+		t.Fatal("expected reconnecting source to resume the stream")
+	}
+	// otherwise the stream is removed
+	src.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.Get("live") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected idle stream to be removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := pub.state.Load().(hlsState); state.tracks != nil {
+		t.Error("expected idle publisher to be closed")
+	}
+	if err := src.WriteHeader(testStreams()); err != ErrSourceReplaced {
+		t.Errorf("expected closed source to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package hls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionTimeout = 30 * time.Second
+	defaultMaxSessions    = 10000
+)
+
+// Sessions tracks the viewers of one or more streams. A viewer is identified
+// by the query parameters propagated by the Authorizer, a cookie, or their
+// address and user agent, in that order of preference.
+type Sessions struct {
+	// Timeout is how long a session lasts after its most recent request. Defaults to 30s.
+	Timeout time.Duration
+	// Cookie is the name of a cookie that identifies the viewer, if the site sets one
+	Cookie string
+	// MaxSessions is the most sessions tracked at once across all streams.
+	// Clients choose their own cookie and user agent, so this bounds the
+	// memory they can use. New viewers beyond it are served but not tracked.
+	// Defaults to 10000.
+	MaxSessions int
+	// OnStart is called when a new viewer makes their first request
+	OnStart func(SessionInfo)
+	// OnEnd is called when a viewer stops making requests or the stream ends
+	OnEnd func(SessionInfo)
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// SessionInfo describes a single viewer of a stream
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	Stream     string    `json:"stream"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	Start      time.Time `json:"start"`
+	LastSeen   time.Time `json:"last_seen"`
+	Requests   int       `json:"requests"`
+	// Bytes is how much media was served to the viewer, indexed by track
+	Bytes []int64 `json:"bytes"`
+}
+
+// SessionStats summarizes the viewers of a stream
+type SessionStats struct {
+	Viewers int `json:"viewers"`
+	// Bytes is how much media was served to current viewers, indexed by track
+	Bytes    []int64       `json:"bytes"`
+	Sessions []SessionInfo `json:"sessions"`
+}
+
+type session struct {
+	SessionInfo
+	timer *time.Timer
+}
+
+// identify the viewer making a request
+func (s *Sessions) key(req *http.Request, stream, query string) (key, addr string) {
+	addr, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		addr = req.RemoteAddr
+	}
+	key = stream + "\x00"
+	if query != "" {
+		key += "q" + query
+	} else if c, err := req.Cookie(s.Cookie); s.Cookie != "" && err == nil {
+		key += "c" + c.Value
+	} else {
+		key += "a" + addr + "\x00" + req.UserAgent()
+	}
+	return key, addr
+}
+
+func (s *Sessions) maxSessions() int {
+	if s.MaxSessions > 0 {
+		return s.MaxSessions
+	}
+	return defaultMaxSessions
+}
+
+// record a request by a viewer, starting a session if needed. Returns nil if
+// there are too many sessions to start another.
+func (s *Sessions) touch(req *http.Request, stream, query string) *session {
+	key, addr := s.key(req, stream, query)
+	now := time.Now()
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*session)
+	}
+	sess := s.sessions[key]
+	started := sess == nil
+	if started && len(s.sessions) >= s.maxSessions() {
+		s.mu.Unlock()
+		return nil
+	}
+	if started {
+		id := sha256.Sum256([]byte(key))
+		sess = &session{SessionInfo: SessionInfo{
+			ID:         hex.EncodeToString(id[:8]),
+			Stream:     stream,
+			RemoteAddr: addr,
+			UserAgent:  req.UserAgent(),
+			Start:      now,
+		}}
+		sess.timer = time.AfterFunc(timeout, func() { s.expire(key, sess) })
+		s.sessions[key] = sess
+	} else {
+		sess.timer.Reset(timeout)
+	}
+	sess.LastSeen = now
+	sess.Requests++
+	var info SessionInfo
+	if started {
+		info = sess.info()
+	}
+	s.mu.Unlock()
+	if started && s.OnStart != nil {
+		s.OnStart(info)
+	}
+	return sess
+}
+
+// add to the bytes served to a viewer
+func (s *Sessions) addBytes(sess *session, trackID int, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(sess.Bytes) <= trackID {
+		sess.Bytes = append(sess.Bytes, 0)
+	}
+	sess.Bytes[trackID] += n
+}
+
+// end a session that has gone quiet
+func (s *Sessions) expire(key string, sess *session) {
+	s.mu.Lock()
+	if s.sessions[key] != sess {
+		s.mu.Unlock()
+		return
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+	if wait := time.Until(sess.LastSeen.Add(timeout)); wait > 0 {
+		// touched while the timer was firing
+		sess.timer.Reset(wait)
+		s.mu.Unlock()
+		return
+	}
+	delete(s.sessions, key)
+	info := sess.info()
+	s.mu.Unlock()
+	if s.OnEnd != nil {
+		s.OnEnd(info)
+	}
+}
+
+// end all sessions for a stream
+func (s *Sessions) endStream(stream string) {
+	var ended []SessionInfo
+	s.mu.Lock()
+	for key, sess := range s.sessions {
+		if sess.Stream == stream {
+			sess.timer.Stop()
+			delete(s.sessions, key)
+			ended = append(ended, sess.info())
+		}
+	}
+	s.mu.Unlock()
+	if s.OnEnd != nil {
+		for _, info := range ended {
+			s.OnEnd(info)
+		}
+	}
+}
+
+// Stats returns the current viewers of a stream
+func (s *Sessions) Stats(stream string) SessionStats {
+	var stats SessionStats
+	s.mu.Lock()
+	for _, sess := range s.sessions {
+		if sess.Stream != stream {
+			continue
+		}
+		stats.Viewers++
+		stats.Sessions = append(stats.Sessions, sess.info())
+		for trackID, n := range sess.Bytes {
+			for len(stats.Bytes) <= trackID {
+				stats.Bytes = append(stats.Bytes, 0)
+			}
+			stats.Bytes[trackID] += n
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(stats.Sessions, func(i, j int) bool { return stats.Sessions[i].Start.Before(stats.Sessions[j].Start) })
+	return stats
+}
+
+// copy the session info so it can be used without the lock
+func (s *session) info() SessionInfo {
+	info := s.SessionInfo
+	info.Bytes = append([]int64(nil), s.Bytes...)
+	return info
+}
+
+// counts bytes written to the client
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(d []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(d)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
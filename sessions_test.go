@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sessionRequest(addr, userAgent, cookie string) *http.Request {
+	req := httptest.NewRequest("GET", "/live/main.m3u8", nil)
+	req.RemoteAddr = addr + ":1234"
+	req.Header.Set("User-Agent", userAgent)
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: "viewer", Value: cookie})
+	}
+	return req
+}
+
+func TestSessionKey(t *testing.T) {
+	s := &Sessions{Cookie: "viewer"}
+	values := []struct {
+		Name   string
+		A, B   *http.Request
+		QA, QB string
+		Same   bool
+	}{
+		{"same client", sessionRequest("192.0.2.1", "a", ""), sessionRequest("192.0.2.1", "a", ""), "", "", true},
+		{"other address", sessionRequest("192.0.2.1", "a", ""), sessionRequest("192.0.2.2", "a", ""), "", "", false},
+		{"other user agent", sessionRequest("192.0.2.1", "a", ""), sessionRequest("192.0.2.1", "b", ""), "", "", false},
+		{"cookie moved", sessionRequest("192.0.2.1", "a", "x"), sessionRequest("192.0.2.2", "b", "x"), "", "", true},
+		{"other cookie", sessionRequest("192.0.2.1", "a", "x"), sessionRequest("192.0.2.1", "a", "y"), "", "", false},
+		{"token moved", sessionRequest("192.0.2.1", "a", "x"), sessionRequest("192.0.2.2", "b", "y"), "token=1", "token=1", true},
+		{"other token", sessionRequest("192.0.2.1", "a", "x"), sessionRequest("192.0.2.1", "a", "x"), "token=1", "token=2", false},
+	}
+	for _, ex := range values {
+		a, _ := s.key(ex.A, "live", ex.QA)
+		b, _ := s.key(ex.B, "live", ex.QB)
+		if same := a == b; same != ex.Same {
+			t.Errorf("%s: expected same session %t, got %t", ex.Name, ex.Same, same)
+		}
+		if c, _ := s.key(ex.A, "other", ex.QA); c == a {
+			t.Errorf("%s: expected another stream to be another session", ex.Name)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	ended := make(chan SessionInfo, 10)
+	var started int
+	s := &Sessions{
+		Timeout: 50 * time.Millisecond,
+		OnStart: func(SessionInfo) { started++ },
+		OnEnd:   func(info SessionInfo) { ended <- info },
+	}
+	sess := s.touch(sessionRequest("192.0.2.1", "a", ""), "live", "")
+	s.addBytes(sess, 1, 100)
+	s.touch(sessionRequest("192.0.2.1", "a", ""), "live", "")
+	s.addBytes(sess, 0, 50)
+	s.touch(sessionRequest("192.0.2.2", "a", ""), "live", "")
+	s.touch(sessionRequest("192.0.2.1", "a", ""), "other", "")
+	if started != 3 {
+		t.Errorf("expected 3 sessions started, got %d", started)
+	}
+	stats := s.Stats("live")
+	if stats.Viewers != 2 || len(stats.Bytes) != 2 || stats.Bytes[0] != 50 || stats.Bytes[1] != 100 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if info := stats.Sessions[0]; info.Requests != 2 || info.RemoteAddr != "192.0.2.1" {
+		t.Errorf("unexpected first session: %+v", info)
+	}
+	// ending a stream ends its sessions right away
+	s.endStream("other")
+	if info := <-ended; info.Stream != "other" {
+		t.Errorf("expected the other stream's session to end, got %+v", info)
+	}
+	// the rest end once they go quiet
+	for i := 0; i < 2; i++ {
+		select {
+		case info := <-ended:
+			if info.Stream != "live" {
+				t.Errorf("unexpected session ended: %+v", info)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected idle sessions to end")
+		}
+	}
+	if stats := s.Stats("live"); stats.Viewers != 0 {
+		t.Errorf("expected no viewers, got %d", stats.Viewers)
+	}
+}
+
+func TestMaxSessions(t *testing.T) {
+	s := &Sessions{MaxSessions: 2}
+	defer s.endStream("live")
+	// each user agent would otherwise be another session
+	for _, ua := range []string{"a", "b", "c", "d"} {
+		sess := s.touch(sessionRequest("192.0.2.1", ua, ""), "live", "")
+		if full := ua > "b"; (sess == nil) != full {
+			t.Errorf("user agent %s: expected session %t, got %v", ua, !full, sess)
+		}
+	}
+	if n := s.Stats("live").Viewers; n != 2 {
+		t.Errorf("expected 2 viewers, got %d", n)
+	}
+	// existing viewers are still tracked
+	if sess := s.touch(sessionRequest("192.0.2.1", "a", ""), "live", ""); sess == nil || sess.Requests != 2 {
+		t.Errorf("expected existing session to continue, got %v", sess)
+	}
+}
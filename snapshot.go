@@ -0,0 +1,74 @@
+package hls
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"eaglesong.dev/hls/internal/fmp4"
+	"eaglesong.dev/hls/internal/ratedetect"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/h264parser"
+)
+
+var annexBStart = []byte{0, 0, 0, 1}
+
+// serve the most recent keyframe, either as a single-frame MP4 or as a raw H.264 elementary stream
+func (p *Publisher) serveSnapshot(rw http.ResponseWriter, req *http.Request, annexB bool) {
+	p.mu.Lock()
+	pkt := p.keyframe
+	var cd av.CodecData
+	if pkt.Data != nil {
+		cd = p.streams[p.vidx]
+	}
+	frameDur := frameDuration(p.rate.Rate())
+	p.mu.Unlock()
+	if cd == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	var blob []byte
+	var ctype string
+	if annexB {
+		h264, ok := cd.(h264parser.CodecData)
+		if !ok {
+			http.Error(rw, "stream is not H.264", http.StatusNotFound)
+			return
+		}
+		blob = keyframeAnnexB(h264, pkt.Data)
+		ctype = "video/h264"
+	} else {
+		var err error
+		blob, err = fmp4.Snapshot(cd, pkt, frameDur)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctype = "video/mp4"
+	}
+	rw.Header().Set("Content-Type", ctype)
+	rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
+	http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(blob))
+}
+
+// get the duration of a single frame, or 0 if the rate is not known yet
+func frameDuration(r ratedetect.Rate) time.Duration {
+	switch {
+	case r.Numerator > 0 && r.Denominator > 0:
+		return time.Second * time.Duration(r.Denominator) / time.Duration(r.Numerator)
+	case r.Float > 0:
+		return time.Duration(float64(time.Second) / r.Float)
+	}
+	return 0
+}
+
+// convert a keyframe to AnnexB with the parameter sets needed to decode it
+func keyframeAnnexB(cd h264parser.CodecData, data []byte) []byte {
+	nalus, _ := h264parser.SplitNALUs(data)
+	var b bytes.Buffer
+	for _, nalu := range append([][]byte{cd.SPS(), cd.PPS()}, nalus...) {
+		b.Write(annexBStart)
+		b.Write(nalu)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,195 @@
+package hls
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"eaglesong.dev/hls/internal/segment"
+)
+
+// UpdateEvent is sent to server-sent event clients for each new part and
+// segment of the primary track
+type UpdateEvent struct {
+	MSN int `json:"msn"`
+	// Part is the index of the part within the segment, omitted for segment events
+	Part        *int       `json:"part,omitempty"`
+	Duration    float64    `json:"duration"`
+	Independent bool       `json:"independent"`
+	Size        int64      `json:"size"`
+	ProgramTime *time.Time `json:"program_time,omitempty"`
+}
+
+// follows the primary track, describing new parts and segments
+type updateWatcher struct {
+	trackID int
+	msn     segment.MSN
+	part    int
+	offset  time.Duration // start of the next part relative to the segment
+	size    int64         // size of the segment so far
+}
+
+// move to the given part, or to the beginning of the segment in progress if msn < 0
+func (w *updateWatcher) seek(state hlsState, msn segment.MSN, part int) {
+	live := state.complete.MSN + 1
+	if msn < 0 || msn > live {
+		msn, part = live, 0
+	} else if msn < state.first {
+		msn, part = state.first, 0
+	}
+	w.msn, w.part = msn, 0
+	w.offset, w.size = 0, 0
+	cursor, _ := state.Get(msn, w.trackID)
+	for ; w.part < part && cursor.Valid(); w.part++ {
+		info, ok := cursor.PartInfo(w.part)
+		if !ok {
+			break
+		}
+		w.offset += info.Duration
+		w.size += int64(info.Length)
+	}
+}
+
+type namedEvent struct {
+	name string
+	id   string
+	ev   UpdateEvent
+}
+
+// collect events for everything that became available since the last poll
+func (w *updateWatcher) poll(state hlsState) (events []namedEvent) {
+	for {
+		if w.msn < state.first {
+			// expired while the client was catching up
+			w.seek(state, state.first, 0)
+		}
+		cursor, _ := state.Get(w.msn, w.trackID)
+		if !cursor.Valid() {
+			return
+		}
+		if cursor.Gap() {
+			// no media, so nothing to announce
+			w.msn++
+			continue
+		}
+		wall := cursor.ProgramTime()
+		parts, final := cursor.Progress()
+		for ; w.part < parts; w.part++ {
+			info, ok := cursor.PartInfo(w.part)
+			if !ok {
+				break
+			}
+			part := w.part
+			ev := UpdateEvent{
+				MSN:         int(w.msn),
+				Part:        &part,
+				Duration:    info.Duration.Seconds(),
+				Independent: info.Independent,
+				Size:        int64(info.Length),
+			}
+			if !wall.IsZero() {
+				pt := wall.Add(w.offset)
+				ev.ProgramTime = &pt
+			}
+			events = append(events, namedEvent{name: "part", id: fmt.Sprintf("%d.%d", w.msn, part), ev: ev})
+			w.offset += info.Duration
+			w.size += int64(info.Length)
+		}
+		if !final {
+			return
+		}
+		first, _ := cursor.PartInfo(0)
+		ev := UpdateEvent{
+			MSN:         int(w.msn),
+			Duration:    cursor.Duration().Seconds(),
+			Independent: first.Independent,
+			Size:        w.size,
+		}
+		if !wall.IsZero() {
+			ev.ProgramTime = &wall
+		}
+		events = append(events, namedEvent{name: "segment", id: strconv.FormatInt(int64(w.msn), 10), ev: ev})
+		w.msn++
+		w.part = 0
+		w.offset, w.size = 0, 0
+	}
+}
+
+// parse the ID of the last event the client saw, which is "msn" for a
+// segment or "msn.part" for a part, and return the next one to send
+func parseLastEventID(id string) (msn segment.MSN, part int, ok bool) {
+	m, p, isPart := strings.Cut(id, ".")
+	n, err := strconv.ParseInt(m, 10, 64)
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	if !isPart {
+		return segment.MSN(n) + 1, 0, true
+	}
+	part, err = strconv.Atoi(p)
+	if err != nil || part < 0 {
+		return 0, 0, false
+	}
+	return segment.MSN(n), part + 1, true
+}
+
+// ServeEvents pushes a server-sent event for each new part ("part" events) and
+// segment ("segment" events) as it is published, with an UpdateEvent as the
+// data. It begins with the parts of the segment in progress, or resumes after
+// Last-Event-ID when a client reconnects.
+func (p *Publisher) ServeEvents(rw http.ResponseWriter, req *http.Request) {
+	if _, ok := p.authorize(rw, req); !ok {
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	// subscribe before loading the state so no updates are missed
+	ch := p.addSub()
+	defer p.delSub(ch)
+	state, _ := p.state.Load().(hlsState)
+	if !state.Valid() {
+		http.NotFound(rw, req)
+		return
+	}
+	w := &updateWatcher{trackID: p.comboID}
+	if w.trackID < 0 {
+		w.trackID = p.vidx
+	}
+	if msn, part, ok := parseLastEventID(req.Header.Get("Last-Event-ID")); ok {
+		w.seek(state, msn, part)
+	} else {
+		w.seek(state, -1, 0)
+	}
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		events := w.poll(state)
+		for _, ev := range events {
+			blob, _ := json.Marshal(ev.ev)
+			if _, err := fmt.Fprintf(rw, "event: %s\nid: %s\ndata: %s\n\n", ev.name, ev.id, blob); err != nil {
+				return
+			}
+		}
+		if len(events) != 0 {
+			flusher.Flush()
+		}
+		select {
+		case <-ch:
+		case <-req.Context().Done():
+			return
+		}
+		state, _ = p.state.Load().(hlsState)
+		if !state.Valid() {
+			// publisher closed
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package hls
+
+import (
+	"net/http"
+	"time"
+
+	"eaglesong.dev/hls/internal/segment"
+)
+
+// a piece of a continuous stream: an initialization segment or a part
+type streamChunk struct {
+	trackID int
+	header  bool
+	data    []byte
+}
+
+// partStreamer follows one or more tracks part by part, for clients that
+// consume the stream as one continuous response instead of polling playlists
+type partStreamer struct {
+	tracks []int
+	// if muxed then the tracks are presented as one movie using the merged header
+	muxed bool
+	msn   segment.MSN
+	// next part to send of each track
+	next []int
+	// initialization segment sent most recently
+	header string
+	// skip ahead to the live edge when the client falls a segment behind
+	skipLagging bool
+	caughtUp    bool
+}
+
+// follow the combined track, or mux the separate tracks if there is no
+// combined track or if it is MPEG-TS and allowTS is false
+func (p *Publisher) newPartStreamer(state hlsState, allowTS bool) *partStreamer {
+	if p.Mode == ModeSingleTrack || (p.Mode == ModeSingleAndSeparate && allowTS) {
+		return &partStreamer{tracks: []int{p.comboID}, next: make([]int, 1)}
+	}
+	tracks := make([]int, len(state.tracks))
+	for i := range tracks {
+		tracks[i] = i
+	}
+	if p.comboID >= 0 {
+		tracks = tracks[:p.comboID]
+	}
+	return &partStreamer{
+		tracks: tracks,
+		muxed:  true,
+		next:   make([]int, len(tracks)),
+	}
+}
+
+// start from the given segment, or from the beginning of the segment in progress if msn < 0
+func (s *partStreamer) seek(state hlsState, msn segment.MSN) {
+	live := state.complete.MSN + 1
+	switch {
+	case msn < 0:
+		msn = live
+	case msn > live+1:
+		msn = live + 1
+	case msn < state.first:
+		msn = state.first
+	}
+	s.msn = msn
+	for i := range s.next {
+		s.next[i] = 0
+	}
+}
+
+// start from the latest segment that has at least d of media buffered after its beginning
+func (s *partStreamer) seekBack(state hlsState, d time.Duration) {
+	live := state.complete.MSN + 1
+	cursor, _ := state.Get(live, s.tracks[0])
+	if !cursor.Valid() {
+		s.seek(state, live)
+		return
+	}
+	target := cursor.Start() + cursor.Duration() - d
+	msn := live
+	for msn > state.first {
+		if cursor.Start() <= target {
+			break
+		}
+		msn--
+		cursor, _ = state.Get(msn, s.tracks[0])
+	}
+	s.seek(state, msn)
+}
+
+func (s *partStreamer) headerFor(state hlsState) trackHeader {
+	if s.muxed {
+		return state.MuxHeaderFor(s.msn)
+	}
+	return state.HeaderFor(s.msn, s.tracks[0])
+}
+
+// collect everything that became available since the last poll. gone is true
+// if the publisher has closed.
+func (s *partStreamer) poll(state hlsState) (chunks []streamChunk, gone bool) {
+	if !state.Valid() {
+		return nil, true
+	}
+	if s.skipLagging && s.caughtUp && s.msn < state.complete.MSN {
+		// a whole segment behind, skip to the next keyframe
+		s.seek(state, -1)
+	}
+	for {
+		if s.msn < state.first {
+			// expired while the client was catching up
+			s.seek(state, -1)
+		}
+		starting := true
+		for _, n := range s.next {
+			if n != 0 {
+				starting = false
+			}
+		}
+		if starting {
+			if hdr := s.headerFor(state); hdr.name != s.header {
+				// codec parameters changed
+				s.header = hdr.name
+				if len(hdr.HeaderContents) != 0 {
+					chunks = append(chunks, streamChunk{trackID: -1, header: true, data: hdr.HeaderContents})
+				}
+			}
+		}
+		final := true
+		for i, trackID := range s.tracks {
+			cursor, _ := state.Get(s.msn, trackID)
+			if !cursor.Valid() {
+				// not started yet
+				final = false
+				continue
+			}
+			parts, done := cursor.Progress()
+			for ; s.next[i] < parts; s.next[i]++ {
+				d := cursor.Part(s.next[i])
+				if d == nil {
+					// released
+					s.seek(state, -1)
+					return chunks, false
+				}
+				chunks = append(chunks, streamChunk{trackID: trackID, data: d})
+			}
+			if !done {
+				final = false
+			}
+		}
+		if !final {
+			break
+		}
+		s.msn++
+		for i := range s.next {
+			s.next[i] = 0
+		}
+	}
+	if s.msn > state.complete.MSN {
+		s.caughtUp = true
+	}
+	return chunks, false
+}
+
+// send chunks to the client as they become available, until it goes away or
+// the publisher closes. sub must be subscribed before state is loaded.
+func (p *Publisher) runStreamer(req *http.Request, query string, s *partStreamer, state hlsState, sub subscriber, done <-chan struct{}, send func([]streamChunk) error) {
+	var sess *session
+	lastMSN := segment.MSN(-1)
+	for {
+		chunks, gone := s.poll(state)
+		if p.Sessions != nil && s.msn != lastMSN {
+			// keep the session alive while the connection is open
+			sess = p.Sessions.touch(req, p.Name, query)
+			lastMSN = s.msn
+		}
+		if len(chunks) != 0 {
+			if err := send(chunks); err != nil {
+				return
+			}
+			for _, chunk := range chunks {
+				p.stats().servedBytes.Add(int64(len(chunk.data)))
+				if sess != nil && !chunk.header {
+					p.Sessions.addBytes(sess, chunk.trackID, int64(len(chunk.data)))
+				}
+			}
+		}
+		if gone {
+			return
+		}
+		select {
+		case <-sub:
+		case <-done:
+			return
+		}
+		state, _ = p.state.Load().(hlsState)
+	}
+}
@@ -0,0 +1,198 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls/internal/fmp4"
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+	"eaglesong.dev/hls/internal/fragment"
+)
+
+// a publisher with two audio tracks, which mustn't collide when muxed together
+func muxedPublisher(t *testing.T) *Publisher {
+	t.Helper()
+	streams := testStreams()
+	streams = append(streams, streams[1])
+	p := &Publisher{Mode: ModeSeparateTracks}
+	if err := p.WriteHeader(streams); err != nil {
+		t.Fatal(err)
+	}
+	f := &feeder{p: p}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// check that a muxed stream declares each track once and that every fragment belongs to one of them
+func checkMuxed(t *testing.T, blob []byte, numTracks int) {
+	t.Helper()
+	atoms, err := fmp4io.ReadFileAtoms(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atoms) < 2 {
+		t.Fatalf("expected a header and fragments, got %d atoms", len(atoms))
+	}
+	moov, ok := atoms[1].(*fmp4io.Movie)
+	if !ok {
+		t.Fatalf("expected the stream to begin with the header, got %T", atoms[1])
+	}
+	samples := make(map[uint32]int)
+	for _, trak := range moov.Tracks {
+		if _, ok := samples[trak.Header.TrackID]; ok {
+			t.Errorf("track ID %d is declared more than once", trak.Header.TrackID)
+		}
+		samples[trak.Header.TrackID] = 0
+	}
+	if len(moov.Tracks) != numTracks {
+		t.Errorf("expected %d tracks in the header, got %d", numTracks, len(moov.Tracks))
+	}
+	for _, atom := range atoms[2:] {
+		moof, ok := atom.(*fmp4io.MovieFrag)
+		if !ok {
+			continue
+		}
+		for _, traf := range moof.Tracks {
+			n, ok := samples[traf.Header.TrackID]
+			if !ok {
+				t.Fatalf("fragment of undeclared track %d", traf.Header.TrackID)
+			}
+			samples[traf.Header.TrackID] = n + len(traf.Run.Entries)
+		}
+	}
+	for trackID, n := range samples {
+		if n == 0 {
+			t.Errorf("no samples for track %d", trackID)
+		}
+	}
+}
+
+func TestMuxHeader(t *testing.T) {
+	p := muxedPublisher(t)
+	defer p.Close()
+	state := p.state.Load().(hlsState)
+	hdr := state.MuxHeaderFor(p.baseMSN)
+	// the separate tracks' segments, concatenated after the merged header, form one movie
+	blob := append([]byte(nil), hdr.HeaderContents...)
+	for trackID := range p.streams {
+		cursor, _ := state.Get(p.baseMSN, trackID)
+		parts, _ := cursor.Progress()
+		for i := 0; i < parts; i++ {
+			blob = append(blob, cursor.Part(i)...)
+		}
+	}
+	checkMuxed(t, blob, 3)
+
+	// tracks that weren't numbered together can't be merged
+	streams := testStreams()
+	frags := make([]fragment.Fragmenter, 2)
+	for i := range frags {
+		frag, err := fmp4.NewTrack(streams[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		frags[i] = frag
+	}
+	if _, err := fmp4.MergeHeaders(frags); err == nil {
+		t.Error("expected duplicate track IDs to be rejected")
+	}
+}
+
+func TestTail(t *testing.T) {
+	p := muxedPublisher(t)
+	srv := httptest.NewServer(http.HandlerFunc(p.Tail))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/tail?start=-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected stream, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "video/mp4" {
+		t.Errorf("expected the header's content type, got %q", ct)
+	}
+	// the stream ends when the publisher closes
+	p.Close()
+	blob, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMuxed(t, blob, 3)
+}
+
+func TestWebSocket(t *testing.T) {
+	p := muxedPublisher(t)
+	srv := httptest.NewServer(http.HandlerFunc(p.ServeWebSocket))
+	defer srv.Close()
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	io.WriteString(c, "GET /ws?msn=0 HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected upgrade, got %d", resp.StatusCode)
+	}
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("unexpected accept key %q", accept)
+	}
+	// the messages are sent as they become available, then closed when the publisher is
+	p.Close()
+	var blob []byte
+	var messages int
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		if hdr[1]&0x80 != 0 {
+			t.Fatal("server frames must not be masked")
+		}
+		length := uint64(hdr[1])
+		switch length {
+		case 126:
+			var ext [2]byte
+			io.ReadFull(r, ext[:])
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			io.ReadFull(r, ext[:])
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+		if op := hdr[0] & 0x0f; op == 0x8 {
+			break
+		} else if op != 0x2 {
+			t.Fatalf("unexpected opcode %d", op)
+		}
+		messages++
+		blob = append(blob, payload...)
+	}
+	// one message for the header, then one per part
+	if messages < 2 || !strings.Contains(string(blob[:16]), "ftyp") {
+		t.Errorf("expected the header and parts, got %d messages", messages)
+	}
+	checkMuxed(t, blob, 3)
+}
@@ -2,42 +2,64 @@ package hls
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 )
 
-// Tail serves the video as one continuous stream, starting from the next segment.
+// Tail serves the video as one continuous stream. Separate tracks are combined
+// into a single movie, except in ModeSingleAndSeparate where the combined
+// MPEG-TS track is served.
+//
+// By default, or if the start query parameter is "current", the stream begins
+// with the first part of the segment in progress. start may instead be a
+// negative number of seconds to begin that far back in the buffer.
 func (p *Publisher) Tail(rw http.ResponseWriter, req *http.Request) {
-	if p.Mode == ModeSeparateTracks {
-		http.NotFound(rw, req)
+	query, ok := p.authorize(rw, req)
+	if !ok {
 		return
 	}
+	// subscribe before loading the state so no updates are missed
+	ch := p.addSub()
+	defer p.delSub(ch)
 	state, _ := p.state.Load().(hlsState)
 	if !state.Valid() {
 		http.NotFound(rw, req)
 		return
 	}
-	rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
-	hdr := p.tracks[p.comboID].hdr
+	s := p.newPartStreamer(state, true)
+	switch v := req.URL.Query().Get("start"); v {
+	case "", "current":
+		s.seek(state, state.complete.MSN+1)
+	default:
+		secs, err := strconv.ParseFloat(v, 64)
+		if err != nil || secs > 0 {
+			http.Error(rw, "invalid start", http.StatusBadRequest)
+			return
+		}
+		s.seekBack(state, time.Duration(-secs*float64(time.Second)))
+	}
+	hdr := s.headerFor(state)
 	if len(hdr.HeaderContents) != 0 {
 		rw.Header().Set("Content-Type", hdr.HeaderContentType)
-		rw.Write(hdr.HeaderContents)
 	} else {
 		rw.Header().Set("Content-Type", hdr.SegmentContentType)
 	}
+	rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
+	rw.WriteHeader(http.StatusOK)
 	flusher, _ := rw.(http.Flusher)
-	// loop until the client hangs up
-	msn := state.complete
-	msn.Part = 0
-	for req.Context().Err() == nil {
-		msn.MSN++
-		// wait for the next segment to begin
-		state = p.waitForSegment(req.Context(), msn)
-		cursor, _ := state.Get(msn.MSN, p.comboID)
-		if !cursor.Valid() || !cursor.Serve(rw, req, -1, true) {
-			http.Error(rw, "", http.StatusGone)
-			return
+	if flusher != nil {
+		// MPEG-TS has no header, so let the client know the stream has started
+		flusher.Flush()
+	}
+	p.runStreamer(req, query, s, state, ch, req.Context().Done(), func(chunks []streamChunk) error {
+		for _, chunk := range chunks {
+			if _, err := rw.Write(chunk.data); err != nil {
+				return err
+			}
 		}
 		if flusher != nil {
 			flusher.Flush()
 		}
-	}
+		return nil
+	})
 }
@@ -0,0 +1,321 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"eaglesong.dev/hls/dashmpd"
+	"eaglesong.dev/hls/internal/naming"
+	"eaglesong.dev/hls/internal/segment"
+	"eaglesong.dev/hls/m3u8"
+	"github.com/nareix/joy4/av"
+)
+
+const (
+	defaultThumbnailInterval = 10 * time.Second
+	defaultThumbnailWidth    = 160
+	defaultThumbnailHeight   = 90
+	defaultThumbnailLayout   = 3
+	thumbnailQueue           = 4
+)
+
+// ThumbnailEncoder renders video keyframes as JPEG images. It is supplied by
+// the application so that no video decoder needs to be bundled.
+type ThumbnailEncoder interface {
+	// EncodeTile decodes each frame, scales it to width x height, and arranges
+	// them left to right and top to bottom in a grid of cols x rows, returning
+	// the grid as a JPEG. There may be fewer frames than cells in the grid.
+	EncodeTile(cd av.CodecData, frames []av.Packet, cols, rows, width, height int) ([]byte, error)
+}
+
+// ThumbnailConfig describes an image track of thumbnails for scrubbing previews
+type ThumbnailConfig struct {
+	// Encoder renders keyframes into JPEG tiles. It is required.
+	Encoder ThumbnailEncoder
+	// Interval is the time between thumbnails. Defaults to 10s.
+	Interval time.Duration
+	// Width and Height of each thumbnail. Defaults to 160x90.
+	Width, Height int
+	// Columns and Rows of thumbnails in each tile. Defaults to 3x3.
+	Columns, Rows int
+}
+
+func (c *ThumbnailConfig) interval() time.Duration {
+	if c.Interval > 0 {
+		return c.Interval
+	}
+	return defaultThumbnailInterval
+}
+
+func (c *ThumbnailConfig) size() (width, height int) {
+	width, height = c.Width, c.Height
+	if width <= 0 || height <= 0 {
+		width, height = defaultThumbnailWidth, defaultThumbnailHeight
+	}
+	return
+}
+
+func (c *ThumbnailConfig) layout() (cols, rows int) {
+	cols, rows = c.Columns, c.Rows
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultThumbnailLayout, defaultThumbnailLayout
+	}
+	return
+}
+
+// frames being collected for the next tile, and tiles that have been encoded
+type thumbState struct {
+	frames []av.Packet
+	first  segment.MSN   // segment in which the first frame was taken
+	start  time.Duration // timestamp of the first frame
+	next   time.Duration // timestamp at which the next frame is due
+	num    int           // number of the next tile to be published
+	jobs   chan thumbJob // nil once closed
+	tiles  []imageTile
+}
+
+type thumbJob struct {
+	cd     av.CodecData
+	frames []av.Packet
+	tile   imageTile
+}
+
+// an encoded grid of thumbnails
+type imageTile struct {
+	num    int
+	msn    segment.MSN // segment in which the first frame was taken
+	start  time.Duration
+	frames int
+	data   []byte
+}
+
+// lock-free snapshot of the image track
+type imageSnapshot struct {
+	playlist []byte
+	tiles    []imageTile
+}
+
+// start collecting thumbnails, if configured
+func (p *Publisher) initThumbnails() {
+	if p.Thumbnails == nil {
+		return
+	}
+	p.thumbs = &thumbState{jobs: make(chan thumbJob, thumbnailQueue)}
+	go p.thumbnailWorker(p.thumbs.jobs)
+}
+
+// consider the keyframe beginning a segment for the next thumbnail
+func (p *Publisher) collectThumbnail(msn segment.MSN) {
+	t := p.thumbs
+	pkt := p.keyframe
+	if len(t.frames) != 0 && pkt.Time < t.next {
+		return
+	}
+	interval := p.Thumbnails.interval()
+	if len(t.frames) == 0 {
+		t.first = msn
+		t.start = pkt.Time
+		t.next = pkt.Time
+	}
+	t.frames = append(t.frames, pkt)
+	t.next += interval
+	if pkt.Time >= t.next {
+		// keyframes are sparser than the interval
+		t.next = pkt.Time + interval
+	}
+	if cols, rows := p.Thumbnails.layout(); len(t.frames) >= cols*rows {
+		p.submitThumbnails()
+	}
+}
+
+// queue collected frames to be encoded as a tile
+func (p *Publisher) submitThumbnails() {
+	t := p.thumbs
+	if len(t.frames) == 0 || t.jobs == nil {
+		return
+	}
+	job := thumbJob{
+		cd:     p.streams[p.vidx],
+		frames: t.frames,
+		tile: imageTile{
+			msn:    t.first,
+			start:  t.start,
+			frames: len(t.frames),
+		},
+	}
+	t.frames = nil
+	select {
+	case t.jobs <- job:
+	default:
+		p.warnf("thumbnail encoder is falling behind, dropping tile at %s", job.tile.start)
+	}
+}
+
+// encode tiles in the background so the publisher isn't held up
+func (p *Publisher) thumbnailWorker(jobs <-chan thumbJob) {
+	cols, rows := p.Thumbnails.layout()
+	width, height := p.Thumbnails.size()
+	for job := range jobs {
+		data, err := p.Thumbnails.Encoder.EncodeTile(job.cd, job.frames, cols, rows, width, height)
+		p.mu.Lock()
+		if err != nil {
+			p.warnf("failed to encode thumbnails: %s", err)
+		} else if p.thumbs.jobs != nil {
+			// only published tiles are numbered so that $Number$ addressing stays consecutive
+			job.tile.num = p.thumbs.num
+			job.tile.data = data
+			p.thumbs.num++
+			p.thumbs.tiles = append(p.thumbs.tiles, job.tile)
+			p.snapshot(0)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// stop encoding thumbnails
+func (p *Publisher) closeThumbnails() {
+	if p.thumbs != nil && p.thumbs.jobs != nil {
+		close(p.thumbs.jobs)
+		p.thumbs.jobs = nil
+	}
+}
+
+// drop tiles whose segments have all been trimmed
+func (p *Publisher) trimThumbnails() {
+	t := p.thumbs
+	if t == nil {
+		return
+	}
+	for len(t.tiles) > 1 && t.tiles[1].msn <= p.baseMSN {
+		t.tiles = t.tiles[1:]
+	}
+}
+
+// the image track is numbered after the media tracks
+func (p *Publisher) imageTrackID() int {
+	return len(p.tracks)
+}
+
+func (p *Publisher) tileName(num string) string {
+	return p.names.Format(naming.Name{Track: p.imageTrackID(), MSN: num, Part: -1, Ext: ".jpg"})
+}
+
+// average bitrate of the image track
+func (p *Publisher) imageBandwidth(tiles []imageTile) int {
+	var size int
+	var frames int
+	for _, tile := range tiles {
+		size += len(tile.data)
+		frames += tile.frames
+	}
+	if frames == 0 {
+		return 0
+	}
+	return int(float64(size*8) / (float64(frames) * p.Thumbnails.interval().Seconds()))
+}
+
+// build the HLS image playlist
+func (p *Publisher) imageSnapshot() imageSnapshot {
+	if p.thumbs == nil {
+		return imageSnapshot{}
+	}
+	tiles := append([]imageTile(nil), p.thumbs.tiles...)
+	cols, rows := p.Thumbnails.layout()
+	width, height := p.Thumbnails.size()
+	interval := p.Thumbnails.interval()
+	first := p.thumbs.num
+	if len(tiles) != 0 {
+		first = tiles[0].num
+	}
+	pl := m3u8.MediaPlaylist{
+		Version:        7,
+		TargetDuration: int(math.Ceil((time.Duration(cols*rows) * interval).Seconds())),
+		MediaSequence:  int64(first),
+		ImagesOnly:     true,
+	}
+	playlist := p.names.Format(naming.Name{Track: p.imageTrackID(), Part: -1, Ext: ".m3u8"})
+	for _, tile := range tiles {
+		pl.Segments = append(pl.Segments, m3u8.Segment{
+			URI:      naming.Rel(playlist, p.tileName(strconv.Itoa(tile.num))),
+			Duration: time.Duration(tile.frames) * interval,
+			Tiles:    &m3u8.Tiles{Width: width, Height: height, Columns: cols, Rows: rows, Duration: interval},
+		})
+	}
+	blob, _ := pl.MarshalText()
+	return imageSnapshot{playlist: blob, tiles: tiles}
+}
+
+// build a DASH adaptation set for the tiles within a period
+func (p *Publisher) imageAdaptationSet(first, last segment.MSN, pto time.Duration) *dashmpd.AdaptationSet {
+	if p.thumbs == nil {
+		return nil
+	}
+	var tiles []imageTile
+	for _, tile := range p.thumbs.tiles {
+		if tile.msn >= first && (last < 0 || tile.msn < last) {
+			tiles = append(tiles, tile)
+		}
+	}
+	if len(tiles) == 0 {
+		return nil
+	}
+	cols, rows := p.Thumbnails.layout()
+	width, height := p.Thumbnails.size()
+	interval := p.Thumbnails.interval()
+	tl := new(dashmpd.SegmentTimeline)
+	for _, tile := range tiles {
+		tl.Segments = append(tl.Segments, dashmpd.Segment{
+			Time:     uint64(tile.start.Milliseconds()),
+			Duration: int((time.Duration(tile.frames) * interval).Milliseconds()),
+		})
+	}
+	return &dashmpd.AdaptationSet{
+		ID:          strconv.Itoa(p.imageTrackID()),
+		ContentType: "image",
+		SegmentTemplate: &dashmpd.SegmentTemplate{
+			Media:                  p.tileName("$Number$"),
+			StartNumber:            dashmpd.Int(tiles[0].num),
+			Timescale:              dashmpd.Int(1000),
+			PresentationTimeOffset: uint64(pto.Milliseconds()),
+			SegmentTimeline:        tl,
+		},
+		Representation: []dashmpd.Representation{{
+			ID:        "thumbnails",
+			Bandwidth: p.imageBandwidth(tiles),
+			Codecs:    "jpeg",
+			MimeType:  "image/jpeg",
+			Width:     cols * width,
+			Height:    rows * height,
+			EssentialProperty: []dashmpd.Descriptor{{
+				SchemeID: "http://dashif.org/thumbnail_tile",
+				Value:    fmt.Sprintf("%dx%d", cols, rows),
+			}},
+		}},
+	}
+}
+
+// serve the image playlist or a tile
+func (p *Publisher) serveImage(rw http.ResponseWriter, req *http.Request, state hlsState, name naming.Name, query string) {
+	if name.MSN == "" && name.Ext == ".m3u8" && state.images.playlist != nil {
+		rw.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		rw.Header().Set("Cache-Control", "max-age=0, no-cache, no-store")
+		http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(addPlaylistQuery(state.images.playlist, query)))
+		return
+	}
+	num, err := strconv.Atoi(name.MSN)
+	if err == nil && name.Ext == ".jpg" {
+		for _, tile := range state.images.tiles {
+			if tile.num == num {
+				rw.Header().Set("Content-Type", "image/jpeg")
+				rw.Header().Set("Cache-Control", "max-age=180, public")
+				http.ServeContent(rw, req, "", time.Time{}, bytes.NewReader(tile.data))
+				return
+			}
+		}
+	}
+	http.NotFound(rw, req)
+}
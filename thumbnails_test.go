@@ -0,0 +1,118 @@
+package hls
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+// renders each tile as the timestamp of its first frame. the first call blocks
+// until release is closed and the third call fails.
+type testThumbEncoder struct {
+	mu      sync.Mutex
+	calls   int
+	release chan struct{}
+}
+
+func (e *testThumbEncoder) EncodeTile(cd av.CodecData, frames []av.Packet, cols, rows, width, height int) ([]byte, error) {
+	e.mu.Lock()
+	e.calls++
+	call := e.calls
+	e.mu.Unlock()
+	switch call {
+	case 1:
+		<-e.release
+	case 3:
+		return nil, errors.New("bad frame")
+	}
+	return []byte(frames[0].Time.String()), nil
+}
+
+func TestThumbnailsRequireEncoder(t *testing.T) {
+	p := &Publisher{Thumbnails: &ThumbnailConfig{}}
+	if err := p.WriteHeader(testStreams()); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestThumbnailNumbering(t *testing.T) {
+	enc := &testThumbEncoder{release: make(chan struct{})}
+	var mu sync.Mutex
+	var dropped int
+	p := &Publisher{
+		Mode:       ModeSeparateTracks,
+		Thumbnails: &ThumbnailConfig{Encoder: enc, Interval: 2 * time.Second, Columns: 1, Rows: 1},
+		OnWarning: func(msg string) {
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Contains(msg, "dropping tile") {
+				dropped++
+			}
+		},
+	}
+	if err := p.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// the encoder is stuck on the first tile, so the queue fills up and later tiles are dropped
+	f := &feeder{p: p}
+	if err := f.until(30 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	close(enc.release)
+	// one tile in progress and a full queue are encoded, and one of those fails
+	const encoded = 1 + thumbnailQueue - 1
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		n := len(p.thumbs.tiles)
+		p.mu.Unlock()
+		if n == encoded {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected %d tiles, got %d", encoded, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	mu.Lock()
+	if dropped == 0 {
+		t.Error("expected tiles to be dropped")
+	}
+	mu.Unlock()
+
+	p.mu.Lock()
+	aset := p.imageAdaptationSet(0, -1, 0)
+	images := p.imageSnapshot()
+	p.mu.Unlock()
+	for i, tile := range images.tiles {
+		if tile.num != i {
+			t.Errorf("tile %d: numbered %d", i, tile.num)
+		}
+	}
+	if !strings.Contains(string(images.playlist), "#EXT-X-MEDIA-SEQUENCE:0\n") {
+		t.Errorf("expected image playlist to start at 0:\n%s", images.playlist)
+	}
+	tmpl := aset.SegmentTemplate
+	segs := tmpl.SegmentTimeline.Segments
+	if len(segs) != encoded {
+		t.Fatalf("expected %d S elements, got %d", encoded, len(segs))
+	}
+	// each tile addressed by $Number$ must be the one at the matching position in the timeline
+	for i, s := range segs {
+		uri := strings.ReplaceAll(tmpl.Media, "$Number$", strconv.Itoa(*tmpl.StartNumber+i))
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest("GET", "/"+uri, nil))
+		expected := (time.Duration(s.Time) * time.Millisecond).String()
+		if rec.Code != 200 {
+			t.Errorf("%s: status %d", uri, rec.Code)
+		} else if got := rec.Body.String(); got != expected {
+			t.Errorf("%s: expected tile at %s, got %s", uri, expected, got)
+		}
+	}
+}
@@ -1,9 +1,17 @@
 package hls
 
 import (
+	"bytes"
 	"fmt"
+	"hash/crc32"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
+	"eaglesong.dev/hls/internal/fmp4"
+	"eaglesong.dev/hls/internal/fragment"
+	"eaglesong.dev/hls/internal/naming"
 	"eaglesong.dev/hls/internal/segment"
 )
 
@@ -14,26 +22,43 @@ const (
 
 // start a new segment
 func (p *Publisher) newSegment(start time.Duration, programTime time.Time) error {
-	if len(p.primary.segments) != 0 {
+	if p.primary.live() {
 		// flush and finalize previous segment
 		if err := p.flush(); err != nil {
 			return err
 		}
-		for _, track := range p.tracks {
-			track.current().Finalize(start)
-		}
+		p.finalizeSegments(start)
+	}
+	if len(p.primary.segments) == 0 && p.baseMSN == 0 && !p.Epoch.IsZero() {
+		p.baseMSN = p.epochMSN(programTime)
+		p.alignSlot = int64(p.baseMSN)
 	}
-	initialDur := p.targetDuration()
 	nextMSN := p.baseMSN + segment.MSN(len(p.primary.segments))
+	if !p.Epoch.IsZero() {
+		nextMSN = p.skipSlots(start, nextMSN)
+	}
+	initialDur := p.targetDuration()
+	if p.thumbs != nil && p.keyframe.Data != nil && p.keyframe.Time == start {
+		p.collectThumbnail(nextMSN)
+	}
 	for trackID, track := range p.tracks {
 		track.frag.NewSegment()
-		name := fmt.Sprintf("%d%s%d%s", trackID, p.pid, nextMSN, track.hdr.SegmentExtension)
-		seg, err := segment.New(name, p.WorkDir, track.hdr.SegmentContentType, start, p.nextDCN, programTime)
+		seg, err := segment.New(p.segmentNames(trackID, nextMSN), p.WorkDir, track.hdr.SegmentContentType, start, p.nextDCN, programTime)
 		if err != nil {
 			return err
 		}
 		// add the new segment and remove the old
 		track.segments = append(track.segments, seg)
+		if p.Observer != nil {
+			p.Observer.SegmentStarted(p.segmentEvent(trackID, nextMSN, seg))
+		}
+	}
+	if p.nextDCN && p.Observer != nil {
+		p.Observer.Discontinuity(int(nextMSN))
+	}
+	if p.nextPeriod {
+		p.addPeriod(nextMSN, start)
+		p.nextPeriod = false
 	}
 	p.trimSegments(initialDur)
 	p.snapshot(initialDur)
@@ -41,6 +66,96 @@ func (p *Publisher) newSegment(start time.Duration, programTime time.Time) error
 	return nil
 }
 
+// decide whether a keyframe should begin a new segment
+func (p *Publisher) segmentBoundary(pkt ExtendedPacket) bool {
+	wall := p.wallClock(pkt)
+	if p.AlignSegments <= 0 || wall.IsZero() {
+		return true
+	}
+	var slot int64
+	if p.Epoch.IsZero() {
+		slot = wall.UnixNano() / int64(p.AlignSegments)
+	} else {
+		slot = int64(wall.Sub(p.Epoch) / p.AlignSegments)
+	}
+	if p.primary.live() && slot <= p.alignSlot {
+		// still in the same interval
+		return false
+	}
+	p.alignSlot = slot
+	return true
+}
+
+// number the next segment by the interval in which it began, so that
+// publishers sharing an epoch agree on segment numbers. intervals that were
+// skipped, e.g. because keyframes were too far apart, are filled with gaps.
+func (p *Publisher) skipSlots(start time.Duration, nextMSN segment.MSN) segment.MSN {
+	slot := segment.MSN(p.alignSlot)
+	if slot <= nextMSN {
+		// began in the same interval as the previous segment, e.g. after a restart
+		p.alignSlot = int64(nextMSN)
+		return nextMSN
+	}
+	for msn := nextMSN; msn < slot; msn++ {
+		for trackID, track := range p.tracks {
+			track.segments = append(track.segments, segment.NewGap(p.segmentNames(trackID, msn), start))
+		}
+	}
+	// DASH numbers segments by their position in the timeline, so skipping
+	// numbers requires a new period
+	p.nextPeriod = p.Mode != ModeSingleTrack
+	return slot
+}
+
+// number the first segment by how many segment intervals have passed since
+// the epoch. checkEpoch ensures that it has a program time after the epoch.
+func (p *Publisher) epochMSN(programTime time.Time) segment.MSN {
+	elapsed := programTime.Sub(p.Epoch)
+	p.firstWall = elapsed
+	return segment.MSN(elapsed / p.AlignSegments)
+}
+
+// segments numbered from the epoch can't begin until the wall-clock time is
+// known, so discard packets until a keyframe with a program time arrives. a
+// program time before the epoch can't be numbered at all.
+func (p *Publisher) checkEpoch(pkt ExtendedPacket) (ok bool, err error) {
+	if !pkt.ProgramTime.IsZero() && pkt.ProgramTime.Before(p.Epoch) {
+		return false, fmt.Errorf("program time %s is before the epoch %s", pkt.ProgramTime.UTC().Format(time.RFC3339Nano), p.Epoch.UTC().Format(time.RFC3339Nano))
+	}
+	if len(p.primary.segments) == 0 && pkt.ProgramTime.IsZero() {
+		if pkt.IsKeyFrame && int(pkt.Idx) == p.vidx {
+			p.warnf("discarding keyframe without a program time, which is needed to number segments from the epoch")
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// estimate the wall-clock time of a packet from the most recent program time
+func (p *Publisher) wallClock(pkt ExtendedPacket) time.Time {
+	if !pkt.ProgramTime.IsZero() {
+		p.wallBase = pkt.ProgramTime
+		p.wallTime = pkt.Time
+		p.setWallClock(pkt.ProgramTime, pkt.Time)
+		return pkt.ProgramTime
+	} else if p.wallBase.IsZero() {
+		return time.Time{}
+	}
+	return p.wallBase.Add(pkt.Time - p.wallTime)
+}
+
+// pass the wall clock on to the fragmenters so that fragments carry producer reference times
+func (p *Publisher) setWallClock(wall time.Time, ts time.Duration) {
+	for _, track := range p.tracks {
+		switch f := track.frag.(type) {
+		case *fmp4.TrackFragmenter:
+			f.SetWallClock(wall, ts)
+		case *fmp4.MovieFragmenter:
+			f.SetWallClock(wall, ts)
+		}
+	}
+}
+
 // calculate the longest segment duration
 func (p *Publisher) targetDuration() time.Duration {
 	maxTime := p.primary.frag.Duration() // pending segment duration
@@ -73,30 +188,60 @@ func (p *Publisher) trimSegments(segmentLen time.Duration) {
 	if n <= 0 {
 		return
 	}
+	oldBase := p.baseMSN
 	p.baseMSN += segment.MSN(n)
-	for _, track := range p.tracks {
-		for _, seg := range track.segments[:n] {
+	for trackID, track := range p.tracks {
+		for i, seg := range track.segments[:n] {
 			if track == p.primary && seg.Discontinuous() {
 				p.baseDCN++
 			}
+			if p.Observer != nil && !seg.Gap() {
+				p.Observer.SegmentReleased(p.segmentEvent(trackID, oldBase+segment.MSN(i), seg))
+			}
 			seg.Release()
 		}
 		track.segments = track.segments[n:]
+		// drop initialization segments that are no longer referenced
+		for len(track.headers) > 1 && track.headers[1].msn <= p.baseMSN {
+			track.headers = track.headers[1:]
+		}
 	}
+	for len(p.muxHeaders) > 1 && p.muxHeaders[1].msn <= p.baseMSN {
+		p.muxHeaders = p.muxHeaders[1:]
+	}
+	p.trimThumbnails()
 }
 
 // make a fragment for every track
 func (p *Publisher) flush() error {
-	for _, track := range p.tracks {
+	m := p.stats()
+	began := time.Now()
+	msn := p.baseMSN + segment.MSN(len(p.primary.segments)-1)
+	for trackID, track := range p.tracks {
 		f, err := track.frag.Fragment()
 		if err != nil {
 			return err
 		} else if f.Bytes != nil {
-			if err := track.current().Append(f); err != nil {
+			seg := track.current()
+			if err := seg.Append(f); err != nil {
 				return err
 			}
+			m.parts.Inc()
+			if p.Observer != nil {
+				part := seg.Parts() - 1
+				p.Observer.PartAppended(PartEvent{
+					Track:       trackID,
+					MSN:         int(msn),
+					Part:        part,
+					Name:        p.segmentName(trackID, msn, part),
+					Duration:    f.Duration,
+					Independent: f.Independent,
+					Bytes:       f.Bytes,
+				})
+			}
 		}
 	}
+	m.fragmentLatency.Observe(time.Since(began).Seconds())
 	return nil
 }
 
@@ -106,3 +251,89 @@ func (t *track) current() *segment.Segment {
 	}
 	return t.segments[len(t.segments)-1]
 }
+
+// returns true if a segment is in progress
+func (t *track) live() bool {
+	seg := t.current()
+	return seg != nil && !seg.Final()
+}
+
+// name the segment files for a track relative to its playlist
+func (p *Publisher) segmentNames(trackID int, msn segment.MSN) func(part int) string {
+	playlist := p.playlistName(trackID)
+	return func(part int) string {
+		return naming.Rel(playlist, p.segmentName(trackID, msn, part))
+	}
+}
+
+// get the name of a track's media playlist
+func (p *Publisher) playlistName(trackID int) string {
+	return p.names.Format(naming.Name{Track: trackID, Part: -1, Ext: ".m3u8"})
+}
+
+// publish a new initialization segment starting with the given MSN.
+//
+// If stable is set then segment names are reused by a restarted publisher, so
+// the initialization segment is named after its contents instead of counting
+// from zero.
+func (t *track) addHeader(names *naming.Template, trackID int, msn segment.MSN, stable bool) {
+	var name string
+	if t.hdr.HeaderName != "" {
+		// name is derived from the fragmenter's, e.g. init.mp4
+		ext := path.Ext(t.hdr.HeaderName)
+		base := strings.TrimSuffix(t.hdr.HeaderName, ext)
+		if stable {
+			base += strconv.FormatUint(uint64(crc32.ChecksumIEEE(t.hdr.HeaderContents)), 10)
+		} else if t.hdrGen != 0 {
+			// make the name unique so that caches don't serve the old one
+			base += strconv.Itoa(t.hdrGen)
+		}
+		name = names.Format(naming.Name{Track: trackID, MSN: base, Part: -1, Ext: ext})
+	}
+	t.hdrGen++
+	t.headers = append(t.headers, trackHeader{
+		Header: t.hdr,
+		name:   name,
+		msn:    msn,
+	})
+}
+
+// publish an initialization segment covering all of the separate tracks,
+// for clients that play them back as a single stream
+func (p *Publisher) addMuxHeader(msn segment.MSN) error {
+	if p.Mode == ModeSingleTrack {
+		return nil
+	}
+	frags := make([]fragment.Fragmenter, len(p.streams))
+	for i := range p.streams {
+		frags[i] = p.tracks[i].frag
+	}
+	blob, err := fmp4.MergeHeaders(frags)
+	if err != nil {
+		return err
+	}
+	if n := len(p.muxHeaders); n != 0 && bytes.Equal(p.muxHeaders[n-1].HeaderContents, blob) {
+		return nil
+	}
+	hdr := p.tracks[p.vidx].hdr
+	hdr.HeaderContents = blob
+	p.muxGen++
+	p.muxHeaders = append(p.muxHeaders, trackHeader{
+		Header: hdr,
+		name:   "mux" + strconv.Itoa(p.muxGen),
+		msn:    msn,
+	})
+	return nil
+}
+
+// get the name of the initialization segment used by the given MSN
+func (t *track) headerName(msn segment.MSN) string {
+	var name string
+	for _, hdr := range t.headers {
+		if hdr.msn > msn {
+			break
+		}
+		name = hdr.name
+	}
+	return name
+}
@@ -0,0 +1,138 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"eaglesong.dev/hls/internal/segment"
+	"eaglesong.dev/hls/internal/timescale"
+)
+
+// check that each segment addressed by $Number$ in the MPD begins where the timeline says it does
+func checkDASHNumbers(t *testing.T, p *Publisher) {
+	t.Helper()
+	track := p.tracks[p.vidx]
+	for _, period := range p.mpd.Period {
+		tmpl := period.AdaptationSet[p.vidx].SegmentTemplate
+		msn := segment.MSN(*tmpl.StartNumber)
+		ts := tmpl.SegmentTimeline.Segments[0].Time
+		for _, s := range tmpl.SegmentTimeline.Segments {
+			for i := 0; i <= s.Repeat; i++ {
+				idx := int(msn - p.baseMSN)
+				if idx < 0 || idx >= len(track.segments) {
+					t.Errorf("period %s: segment %d doesn't exist", period.ID, msn)
+					return
+				}
+				seg := track.segments[idx]
+				if start := timescale.ToScale(seg.Start(), track.frag.TimeScale()); seg.Gap() || start != ts {
+					t.Errorf("period %s: segment %d starts at %d, but the timeline has %d", period.ID, msn, start, ts)
+				}
+				msn++
+				ts += uint64(s.Duration)
+			}
+		}
+	}
+}
+
+func TestAlignedNumbering(t *testing.T) {
+	const align = 2 * time.Second
+	publish := func(offset time.Duration, gop int) *Publisher {
+		p := &Publisher{Mode: ModeSeparateTracks, Epoch: testEpoch, AlignSegments: align}
+		if err := p.WriteHeader(testStreams()); err != nil {
+			t.Fatal(err)
+		}
+		f := &feeder{p: p, gop: gop, start: testEpoch.Add(offset)}
+		if err := f.until(30 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// started at different times, and one with keyframes twice as far apart as the interval
+	pubs := []*Publisher{
+		publish(10*time.Second, 0),
+		publish(17*time.Second, 0),
+		publish(12*time.Second, 120),
+	}
+	numbers := make(map[time.Time]segment.MSN)
+	for i, p := range pubs {
+		p.mu.Lock()
+		for j, seg := range p.primary.segments {
+			if seg.Gap() {
+				continue
+			}
+			msn := p.baseMSN + segment.MSN(j)
+			wall := seg.ProgramTime()
+			if expected := segment.MSN(wall.Sub(testEpoch) / align); msn != expected {
+				t.Errorf("publisher %d: segment at %s numbered %d, expected %d", i, wall, msn, expected)
+			}
+			if other, ok := numbers[wall]; ok && other != msn {
+				t.Errorf("publisher %d: segment at %s numbered %d, but %d by another publisher", i, wall, msn, other)
+			}
+			numbers[wall] = msn
+		}
+		checkDASHNumbers(t, p)
+		p.mu.Unlock()
+		state := p.state.Load().(hlsState)
+		playlist := string(state.tracks[p.vidx].playlist)
+		if hasGap := strings.Contains(playlist, "#EXT-X-GAP\n"); hasGap != (i == 2) {
+			t.Errorf("publisher %d: unexpected gaps in playlist:\n%s", i, playlist)
+		}
+		p.Close()
+	}
+}
+
+func TestEpochProgramTime(t *testing.T) {
+	var warnings int
+	p := &Publisher{
+		Mode:          ModeSeparateTracks,
+		Epoch:         testEpoch,
+		AlignSegments: 2 * time.Second,
+		OnWarning:     func(string) { warnings++ },
+	}
+	if err := p.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// without a program time nothing can be numbered
+	f := &feeder{p: p}
+	if err := f.until(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.primary.segments); n != 0 {
+		t.Errorf("expected no segments without a program time, got %d", n)
+	}
+	if warnings == 0 {
+		t.Error("expected a warning about the missing program time")
+	}
+	// the next keyframe is at 6s and 107s past the epoch
+	f.start = testEpoch.Add(101 * time.Second)
+	if err := f.until(20 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if p.baseMSN != 53 {
+		t.Errorf("expected first segment to be numbered 53, got %d", p.baseMSN)
+	}
+	if ast := p.mpd.AvailabilityStartTime; ast == nil || !ast.Equal(testEpoch) {
+		t.Errorf("expected availabilityStartTime to be the epoch, got %s", ast)
+	}
+	// the first segment is presented at its program time
+	if start := p.mpd.Period[0].Start.Duration; start != 107*time.Second {
+		t.Errorf("expected first period to start 107s after the epoch, got %s", start)
+	}
+}
+
+func TestBeforeEpoch(t *testing.T) {
+	p := &Publisher{Epoch: testEpoch, AlignSegments: 2 * time.Second}
+	if err := p.WriteHeader(testStreams()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	f := &feeder{p: p, start: testEpoch.Add(-time.Hour)}
+	if err := f.until(time.Second); err == nil {
+		t.Error("expected an error for a program time before the epoch")
+	}
+	if n := len(p.primary.segments); n != 0 {
+		t.Errorf("expected no segments, got %d", n)
+	}
+}
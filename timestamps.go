@@ -0,0 +1,123 @@
+package hls
+
+import (
+	"time"
+
+	"eaglesong.dev/hls/internal/fmp4"
+	"github.com/nareix/joy4/av"
+)
+
+const (
+	defaultMaxTimestampJump = 10 * time.Second
+	defaultMaxDrift         = 40 * time.Millisecond
+	// small steps backwards are tolerated when timestamps are normalized
+	maxNormalizedRewind = 500 * time.Millisecond
+)
+
+// DriftStats describes drift between audio and video timestamps
+type DriftStats struct {
+	// Drift is how far audio timestamps currently lead video, relative to where they were at the start of the stream
+	Drift time.Duration
+	// MaxDrift is the largest drift observed in either direction
+	MaxDrift time.Duration
+	// Correction is the shift being applied to audio timestamps
+	Correction time.Duration
+	// Corrections counts how many times the correction was changed
+	Corrections int
+	// Adjusted counts packets whose timestamps were moved to keep decode times increasing
+	Adjusted int
+}
+
+// timestamp continuity of a single incoming stream
+type tsState struct {
+	seen   bool
+	last   time.Duration // timestamp of the previous packet
+	dur    time.Duration // interval between the previous two packets
+	offset time.Duration // added to incoming timestamps when rebasing
+}
+
+// detect timestamps that jump backwards or too far forwards. A discontinuity
+// is inserted and the packet's timestamp is rebased if configured to.
+//
+// Each stream is rebased separately, so a jump in one stream doesn't move the
+// others. When the whole source jumps, every stream is rebased to continue
+// from its own previous timestamp, which keeps them in sync.
+func (p *Publisher) checkJump(pkt *ExtendedPacket) error {
+	maxJump := p.MaxTimestampJump
+	if maxJump == 0 {
+		maxJump = defaultMaxTimestampJump
+	}
+	if maxJump < 0 || int(pkt.Idx) >= len(p.ts) {
+		return nil
+	}
+	ts := &p.ts[pkt.Idx]
+	pkt.Time += ts.offset
+	delta := pkt.Time - ts.last
+	var minDelta time.Duration
+	if p.norm != nil {
+		minDelta = -maxNormalizedRewind
+	}
+	if ts.seen && (delta < minDelta || delta > maxJump) {
+		if p.RebaseTimestamps {
+			// continue one interval after the previous packet
+			adjust := ts.last + ts.dur - pkt.Time
+			ts.offset += adjust
+			pkt.Time += adjust
+			delta = ts.dur
+			p.nextDCN = true
+			p.nextPeriod = p.Mode != ModeSingleTrack
+		} else {
+			// timestamps queued in the fragmenters can't be reconciled with
+			// the new ones, so start over at the next keyframe
+			if err := p.restartTracks(p.streams); err != nil {
+				return err
+			}
+			p.snapshot(0)
+			for i := range p.ts {
+				p.ts[i].seen = false
+			}
+		}
+	}
+	if ts.seen && delta > 0 {
+		ts.dur = delta
+	}
+	ts.last = pkt.Time
+	ts.seen = true
+	return nil
+}
+
+// combined fragmenters that can shift the timestamps of one of their streams
+type correctable interface {
+	SetCorrection(idx int, d time.Duration)
+}
+
+// normalize a packet's timestamps and pass any drift correction on to the audio fragmenters
+func (p *Publisher) normalize(pkt *av.Packet) {
+	p.norm.Normalize(pkt)
+	correction := p.norm.Correction()
+	for i, cd := range p.streams {
+		if cd.Type().IsVideo() {
+			continue
+		}
+		if p.Mode != ModeSingleTrack {
+			if f, ok := p.tracks[i].frag.(*fmp4.TrackFragmenter); ok {
+				f.SetCorrection(correction)
+			}
+		}
+		if p.combo != nil {
+			if f, ok := p.combo.frag.(correctable); ok {
+				f.SetCorrection(i, correction)
+			}
+		}
+	}
+}
+
+// DriftStats returns statistics about drift between audio and video. They are only collected if NormalizeTimestamps is set.
+func (p *Publisher) DriftStats() DriftStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.norm == nil {
+		return DriftStats{}
+	}
+	return DriftStats(p.norm.Stats())
+}
@@ -0,0 +1,37 @@
+package hls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+func TestRebaseOneStream(t *testing.T) {
+	p := &Publisher{RebaseTimestamps: true}
+	p.ts = make([]tsState, 2)
+	write := func(idx int8, ts time.Duration) time.Duration {
+		pkt := ExtendedPacket{Packet: av.Packet{Idx: idx, Time: ts}}
+		if err := p.checkJump(&pkt); err != nil {
+			t.Fatal(err)
+		}
+		return pkt.Time
+	}
+	for i := 0; i < 10; i++ {
+		write(0, time.Duration(i)*100*time.Millisecond)
+		write(1, time.Duration(i)*20*time.Millisecond)
+	}
+	// only the second stream jumps
+	if got := write(1, time.Hour); got != 200*time.Millisecond {
+		t.Errorf("jumped stream: expected 200ms, got %s", got)
+	}
+	if got := write(1, time.Hour+20*time.Millisecond); got != 220*time.Millisecond {
+		t.Errorf("jumped stream: expected 220ms, got %s", got)
+	}
+	if got := write(0, time.Second); got != time.Second {
+		t.Errorf("other stream: expected 1s, got %s", got)
+	}
+	if !p.nextDCN {
+		t.Error("expected a discontinuity")
+	}
+}
@@ -0,0 +1,57 @@
+package hls
+
+import (
+	"net/http"
+	"strconv"
+
+	"eaglesong.dev/hls/internal/segment"
+	"eaglesong.dev/hls/internal/websocket"
+)
+
+// ServeWebSocket streams fragmented MP4 over a WebSocket for playback with
+// Media Source Extensions. The initialization segment is sent first, then
+// each part as a binary message as soon as it is ready. Separate tracks are
+// sent together as one movie.
+//
+// The msn query parameter chooses the segment to start from, otherwise it
+// starts at the beginning of the segment in progress. If the client falls a
+// whole segment behind, it skips ahead to the next keyframe.
+func (p *Publisher) ServeWebSocket(rw http.ResponseWriter, req *http.Request) {
+	query, ok := p.authorize(rw, req)
+	if !ok {
+		return
+	}
+	start := segment.MSN(-1)
+	if v := req.URL.Query().Get("msn"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(rw, "invalid msn", http.StatusBadRequest)
+			return
+		}
+		start = segment.MSN(n)
+	}
+	// subscribe before loading the state so no updates are missed
+	ch := p.addSub()
+	defer p.delSub(ch)
+	state, _ := p.state.Load().(hlsState)
+	if !state.Valid() {
+		http.NotFound(rw, req)
+		return
+	}
+	s := p.newPartStreamer(state, false)
+	s.skipLagging = true
+	s.seek(state, start)
+	conn, err := websocket.Upgrade(rw, req)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	p.runStreamer(req, query, s, state, ch, conn.Done(), func(chunks []streamChunk) error {
+		for _, chunk := range chunks {
+			if err := conn.WriteBinary(chunk.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}